@@ -0,0 +1,346 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator is auth provider of request, Authorize set credential to request,
+// Unauthorized is called when response is 401, returns if the request can be retried
+type Authenticator interface {
+	Authorize(req *http.Request) error
+	Unauthorized(req *http.Request) bool
+}
+
+// BasicAuth is http basic auth provider
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// BearerAuth is static bearer token auth provider
+type BearerAuth struct {
+	Token string
+}
+
+// OAuth2 is OAuth2 auth provider of client credentials grant, the refresh token grant is used
+// if RefreshToken is set or returned by token endpoint, the refresh token rejected by token endpoint
+// is dropped and client credentials grant is used instead, the token is cached until expiry,
+// and only one concurrent request fetches new token, Request is used for token endpoint
+type OAuth2 struct {
+	TokenURL     string
+	ClientId     string
+	ClientSecret string
+	Scopes       []string
+	RefreshToken string
+	ExpiryDelta  time.Duration
+	Request      *Request
+	mutex        sync.Mutex
+	token        *Token
+	fetching     *tokenCall
+}
+
+// Token is OAuth2 access token
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// tokenCall is in-flight token fetching
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewBasicAuth returns a new http basic auth provider
+func NewBasicAuth(username, password string) *BasicAuth {
+	return &BasicAuth{
+		Username: username,
+		Password: password,
+	}
+}
+
+// NewBearerAuth returns a new static bearer token auth provider
+func NewBearerAuth(token string) *BearerAuth {
+	return &BearerAuth{
+		Token: token,
+	}
+}
+
+// NewOAuth2 returns a new OAuth2 auth provider, token is expired 10 seconds early by default
+func NewOAuth2(tokenURL, clientId, clientSecret string, scopes ...string) *OAuth2 {
+	return &OAuth2{
+		TokenURL:     tokenURL,
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		ExpiryDelta:  10 * time.Second,
+		Request:      New(),
+	}
+}
+
+// SetAuth set auth provider of request, request is retried once after refreshing if response is 401
+func (r *Request) SetAuth(auth Authenticator) *Request {
+	r.Auth = auth
+	return r
+}
+
+// SetBasicAuth set http basic auth of request
+func (r *Request) SetBasicAuth(username, password string) *Request {
+	return r.SetAuth(NewBasicAuth(username, password))
+}
+
+// SetBearerAuth set static bearer token of request
+func (r *Request) SetBearerAuth(token string) *Request {
+	return r.SetAuth(NewBearerAuth(token))
+}
+
+// Authorize set basic auth header to request
+func (a *BasicAuth) Authorize(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// Unauthorized returns false as the credential can not be refreshed
+func (a *BasicAuth) Unauthorized(req *http.Request) bool {
+	return false
+}
+
+// Authorize set bearer token header to request
+func (a *BearerAuth) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// Unauthorized returns false as the token can not be refreshed
+func (a *BearerAuth) Unauthorized(req *http.Request) bool {
+	return false
+}
+
+// Authorize set access token header to request, new token is fetched if not cached or expired
+func (o *OAuth2) Authorize(req *http.Request) error {
+	t, err := o.Token(req.Context())
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", t.TokenType+" "+t.AccessToken)
+
+	return nil
+}
+
+// Unauthorized drop the cached token if it is used by the request, returns true for retrying
+func (o *OAuth2) Unauthorized(req *http.Request) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.token != nil && req.Header.Get("Authorization") == o.token.TokenType+" "+o.token.AccessToken {
+		if o.token.RefreshToken != "" {
+			o.RefreshToken = o.token.RefreshToken
+		}
+		o.token = nil
+	}
+
+	return true
+}
+
+// Token returns cached token if not expired, else fetch new token from token endpoint
+func (o *OAuth2) Token(ctx context.Context) (*Token, error) {
+	o.mutex.Lock()
+	if o.token != nil && (o.token.Expiry.IsZero() || time.Now().Before(o.token.Expiry)) {
+		t := o.token
+		o.mutex.Unlock()
+		return t, nil
+	}
+
+	call := o.fetching
+	if call == nil {
+		call = &tokenCall{done: make(chan struct{})}
+		o.fetching = call
+		refreshToken := o.RefreshToken
+		if o.token != nil && o.token.RefreshToken != "" {
+			refreshToken = o.token.RefreshToken
+		}
+		o.mutex.Unlock()
+
+		var rejected bool
+		call.token, rejected, call.err = o.fetch(ctx, refreshToken)
+
+		o.mutex.Lock()
+		if call.err != nil && rejected && refreshToken != "" {
+			o.dropRefreshToken(refreshToken)
+			o.mutex.Unlock()
+			call.token, _, call.err = o.fetch(ctx, "")
+			o.mutex.Lock()
+		}
+		if call.err == nil {
+			o.token = call.token
+		}
+		o.fetching = nil
+		o.mutex.Unlock()
+		close(call.done)
+
+		return call.token, call.err
+	}
+	o.mutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.token, call.err
+	}
+}
+
+// dropRefreshToken remove the refresh token rejected by token endpoint, client credentials is used instead
+func (o *OAuth2) dropRefreshToken(refreshToken string) {
+	if o.RefreshToken == refreshToken {
+		o.RefreshToken = ""
+	}
+
+	if o.token != nil && o.token.RefreshToken == refreshToken {
+		t := *o.token
+		t.RefreshToken = ""
+		o.token = &t
+	}
+}
+
+// fetch request new token from token endpoint, rejected is true if the endpoint responds error
+func (o *OAuth2) fetch(ctx context.Context, refreshToken string) (token *Token, rejected bool, err error) {
+	form := FormParam{}
+	if refreshToken != "" {
+		form["grant_type"] = "refresh_token"
+		form["refresh_token"] = refreshToken
+	} else {
+		form["grant_type"] = "client_credentials"
+	}
+
+	if len(o.Scopes) > 0 {
+		form["scope"] = strings.Join(o.Scopes, " ")
+	}
+
+	req := o.Request
+	if req == nil {
+		req = New()
+	}
+
+	header := Header{}
+	if o.ClientId != "" {
+		auth := url.QueryEscape(o.ClientId) + ":" + url.QueryEscape(o.ClientSecret)
+		header["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+	}
+
+	rsp, err := req.Post(ctx, o.TokenURL, form, header)
+	if err != nil {
+		return nil, false, fmt.Errorf("xhttp: fetch token failed: %s", err.Error())
+	}
+
+	defer rsp.Close()
+	if rsp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(rsp.Response.Body)
+		return nil, true, fmt.Errorf("xhttp: fetch token failed: %s %s", rsp.Response.Status, strings.TrimSpace(string(body)))
+	}
+
+	json, err := rsp.Json()
+	if err != nil {
+		return nil, true, fmt.Errorf("xhttp: decode token failed: %s", err.Error())
+	}
+
+	t := &Token{
+		AccessToken:  json.Get("access_token").MustString(""),
+		TokenType:    json.Get("token_type").MustString("Bearer"),
+		RefreshToken: json.Get("refresh_token").MustString(""),
+	}
+
+	if t.AccessToken == "" {
+		return nil, true, fmt.Errorf("xhttp: fetch token failed: access_token is empty")
+	}
+
+	if strings.EqualFold(t.TokenType, "bearer") {
+		t.TokenType = "Bearer"
+	}
+
+	if t.RefreshToken == "" {
+		t.RefreshToken = refreshToken
+	}
+
+	if expiresIn := json.Get("expires_in").MustInt64(0); expiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(expiresIn)*time.Second - o.ExpiryDelta)
+	}
+
+	return t, false, nil
+}
+
+// auth returns sender wrapped by auth provider, the request is retried once if response is 401
+func (r *Request) auth(send Sender) Sender {
+	if r.Auth == nil {
+		return send
+	}
+
+	auth := r.Auth
+	return func(req *http.Request) (*http.Response, error) {
+		err := auth.Authorize(req)
+		if err != nil {
+			return nil, err
+		}
+
+		rsp, err := send(req)
+		if err != nil || rsp.StatusCode != http.StatusUnauthorized {
+			return rsp, err
+		}
+
+		if !auth.Unauthorized(req) {
+			return rsp, nil
+		}
+
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return rsp, nil
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return rsp, nil
+			}
+			req.Body = body
+		}
+
+		rsp.Body.Close()
+
+		err = auth.Authorize(req)
+		if err != nil {
+			return nil, err
+		}
+
+		return send(req)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestBasicAndBearerAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); ok && u == "likexian" && p == "secret" {
+			w.Write([]byte("basic"))
+			return
+		}
+		if r.Header.Get("Authorization") == "Bearer token" {
+			w.Write([]byte("bearer"))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	rsp, err := New().SetBasicAuth("likexian", "secret").Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "basic")
+
+	rsp, err = New().SetBearerAuth("token").Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "bearer")
+
+	rsp, err = New().SetBearerAuth("bad").Post(ctx, ts.URL, FormParam{"a": "b"})
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusUnauthorized)
+}
+
+func TestOAuth2(t *testing.T) {
+	var fetched int32
+	var rejectRefresh int32
+	var mutex sync.Mutex
+	current := ""
+	grants := []string{}
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "client" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		if r.FormValue("grant_type") == "refresh_token" && atomic.LoadInt32(&rejectRefresh) == 1 {
+			mutex.Lock()
+			grants = append(grants, "rejected "+r.FormValue("refresh_token"))
+			mutex.Unlock()
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		n := atomic.AddInt32(&fetched, 1)
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		current = fmt.Sprintf("token-%d", n)
+		grants = append(grants, r.FormValue("grant_type")+" "+r.FormValue("refresh_token")+" "+r.FormValue("scope"))
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600, "refresh_token": "refresh-%d"}`, n, n)
+	}))
+	defer tokenServer.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		token := current
+		mutex.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		w.Write([]byte(token + " " + r.FormValue("v")))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	auth := NewOAuth2(tokenServer.URL, "client", "secret", "read", "write")
+	req := New().SetAuth(auth)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := req.Get(ctx, ts.URL)
+			assert.Nil(t, err)
+			text, err := rsp.String()
+			assert.Nil(t, err)
+			assert.Equal(t, text, "token-1 ")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, atomic.LoadInt32(&fetched), int32(1))
+
+	mutex.Lock()
+	current = "revoked"
+	mutex.Unlock()
+
+	rsp, err := req.Post(ctx, ts.URL, FormParam{"v": "post"})
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "token-2 post")
+	assert.Equal(t, atomic.LoadInt32(&fetched), int32(2))
+	assert.Equal(t, grants, []string{"client_credentials  read write", "refresh_token refresh-1 read write"})
+
+	// the rejected refresh token is dropped, and client credentials is used instead
+	atomic.StoreInt32(&rejectRefresh, 1)
+	mutex.Lock()
+	current = "revoked"
+	mutex.Unlock()
+
+	rsp, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "token-3 ")
+	assert.Equal(t, grants[2:], []string{"rejected refresh-2", "client_credentials  read write"})
+	assert.Equal(t, auth.RefreshToken, "")
+	assert.Equal(t, auth.token.RefreshToken, "refresh-3")
+	atomic.StoreInt32(&rejectRefresh, 0)
+
+	rsp, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, atomic.LoadInt32(&fetched), int32(3))
+
+	auth.ExpiryDelta = time.Hour
+	auth.token.Expiry = time.Now()
+	for i := 0; i < 2; i++ {
+		tk, err := auth.Token(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, tk.AccessToken, "token-4")
+		assert.True(t, tk.Expiry.Before(time.Now()))
+		tk.Expiry = time.Time{}
+	}
+
+	bad := NewOAuth2(tokenServer.URL, "client", "bad")
+	_, err = New().SetAuth(bad).Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid_client")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bad.fetching = &tokenCall{done: make(chan struct{})}
+	_, err = bad.Token(ctx)
+	assert.Equal(t, err, context.Canceled)
+}
+
+func TestOAuth2Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.Write([]byte(`{"token_type": "bearer"}`))
+		case "/json":
+			w.Write([]byte(`{`))
+		default:
+			w.Write([]byte(`{"access_token": "likexian", "token_type": "mac"}`))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	_, err := NewOAuth2(ts.URL+"/empty", "", "").Token(ctx)
+	assert.NotNil(t, err)
+
+	_, err = NewOAuth2(ts.URL+"/json", "", "").Token(ctx)
+	assert.NotNil(t, err)
+
+	_, err = NewOAuth2("http://127.0.0.1:1/", "", "").Token(ctx)
+	assert.NotNil(t, err)
+
+	auth := NewOAuth2(ts.URL, "", "")
+	auth.Request = nil
+	tk, err := auth.Token(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, tk.TokenType, "mac")
+	assert.True(t, tk.Expiry.IsZero())
+
+	req, _ := http.NewRequest("GET", ts.URL, strings.NewReader("likexian"))
+	err = auth.Authorize(req)
+	assert.Nil(t, err)
+	assert.Equal(t, req.Header.Get("Authorization"), "mac likexian")
+	assert.True(t, auth.Unauthorized(req))
+	assert.True(t, auth.token == nil)
+}
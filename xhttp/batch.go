@@ -0,0 +1,255 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/likexian/gokit/xlump"
+	"github.com/likexian/gokit/xtime"
+)
+
+// defaultBatchConcurrency is default concurrency of batch
+const defaultBatchConcurrency = 10
+
+// BatchItem is request of batch, Method is GET if not set, Args are passed to Do
+type BatchItem struct {
+	Method string
+	URL    string
+	Args   []interface{}
+}
+
+// BatchResult is result of batch item, the response body is read into memory,
+// Time is milliseconds from sending to body received, Canceled is true if it is stopped by context
+type BatchResult struct {
+	Index    int
+	Response *Response
+	Error    error
+	Time     int64
+	Canceled bool
+}
+
+// BatchStats storing aggregated stats of batch, time is in milliseconds,
+// the time stats are computed from the items not canceled
+type BatchStats struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	Canceled  int
+	Time      int64
+	MinTime   int64
+	MaxTime   int64
+	AvgTime   int64
+	P50Time   int64
+	P90Time   int64
+	P99Time   int64
+}
+
+// Batch is concurrent requests runner, at most Concurrency requests are sending at the same time,
+// the rest of batch is canceled after the first error if FailFast is true
+type Batch struct {
+	Request     *Request
+	Concurrency int
+	FailFast    bool
+}
+
+// batchTask is task of batch queue
+type batchTask struct {
+	index int
+	item  BatchItem
+}
+
+// NewBatch returns a new batch runner of request, concurrency is 10 if not set
+func (r *Request) NewBatch(concurrency int) *Batch {
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	return &Batch{
+		Request:     r,
+		Concurrency: concurrency,
+	}
+}
+
+// Do send requests of items concurrently, returns results in order of items and the stats,
+// ctx is shared by all the items, so cancel it stops the whole batch
+func (b *Batch) Do(ctx context.Context, items ...BatchItem) ([]BatchResult, BatchStats) {
+	startAt := xtime.Ms()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	if concurrency > len(items) {
+		concurrency = len(items)
+	}
+
+	work := func(t xlump.Task) xlump.Task {
+		task := t.(batchTask)
+		result := b.do(ctx, task)
+		if result.Error != nil && !result.Canceled && b.FailFast {
+			cancel()
+		}
+		return result
+	}
+
+	merge := func(s xlump.Task, t xlump.Task) xlump.Task {
+		result := t.(BatchResult)
+		s.([]BatchResult)[result.Index] = result
+		return s
+	}
+
+	queue := xlump.New(len(items))
+	queue.SetWorker(work, concurrency)
+	queue.SetMerger(merge, make([]BatchResult, len(items)))
+
+	for i, v := range items {
+		queue.Add(batchTask{index: i, item: v})
+	}
+
+	results := queue.Wait().([]BatchResult)
+
+	stats := batchStats(results)
+	stats.Time = xtime.Ms() - startAt
+
+	return results, stats
+}
+
+// do send request of batch task and read the response body
+func (b *Batch) do(ctx context.Context, task batchTask) BatchResult {
+	result := BatchResult{
+		Index: task.index,
+	}
+
+	if ctx.Err() != nil {
+		result.Error = ctx.Err()
+		result.Canceled = true
+		return result
+	}
+
+	method := task.item.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	startAt := xtime.Ms()
+	rsp, err := b.Request.Do(ctx, method, task.item.URL, task.item.Args...)
+	result.Response = rsp
+	if rsp != nil && rsp.Response != nil && rsp.Response.Body != nil {
+		if _, e := rsp.buffer(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	result.Time = xtime.Ms() - startAt
+	if err != nil {
+		result.Error = err
+		result.Canceled = ctx.Err() != nil
+	}
+
+	return result
+}
+
+// buffer read the response body into memory limited by size, the body can be read again
+func (r *Response) buffer() ([]byte, error) {
+	defer r.Response.Body.Close()
+
+	var body io.Reader = r.Response.Body
+	if r.maxSize > 0 {
+		body = io.LimitReader(body, r.maxSize+1)
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.maxSize > 0 && int64(len(b)) > r.maxSize {
+		return nil, fmt.Errorf("xhttp: response body is larger than %d bytes", r.maxSize)
+	}
+
+	r.Response.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	return b, nil
+}
+
+// batchStats returns the stats of batch results
+func batchStats(results []BatchResult) BatchStats {
+	stats := BatchStats{
+		Total: len(results),
+	}
+
+	times := []int64{}
+	for _, v := range results {
+		switch {
+		case v.Canceled:
+			stats.Canceled++
+		case v.Error != nil:
+			stats.Failed++
+		default:
+			stats.Succeeded++
+		}
+		if !v.Canceled {
+			times = append(times, v.Time)
+		}
+	}
+
+	if len(times) == 0 {
+		return stats
+	}
+
+	sort.Slice(times, func(i, j int) bool {
+		return times[i] < times[j]
+	})
+
+	sum := int64(0)
+	for _, v := range times {
+		sum += v
+	}
+
+	stats.MinTime = times[0]
+	stats.MaxTime = times[len(times)-1]
+	stats.AvgTime = sum / int64(len(times))
+	stats.P50Time = percentile(times, 0.50)
+	stats.P90Time = percentile(times, 0.90)
+	stats.P99Time = percentile(times, 0.99)
+
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted times
+func percentile(times []int64, p float64) int64 {
+	i := int(math.Ceil(p*float64(len(times)))) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return times[i]
+}
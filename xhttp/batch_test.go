@@ -0,0 +1,148 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestBatchStats(t *testing.T) {
+	results := []BatchResult{}
+	for i := 1; i <= 100; i++ {
+		results = append(results, BatchResult{Time: int64(i)})
+	}
+	results = append(results, BatchResult{Error: fmt.Errorf("failed"), Time: 200})
+	results = append(results, BatchResult{Error: context.Canceled, Canceled: true, Time: 1000})
+
+	stats := batchStats(results)
+	assert.Equal(t, stats, BatchStats{
+		Total:     102,
+		Succeeded: 100,
+		Failed:    1,
+		Canceled:  1,
+		MinTime:   1,
+		MaxTime:   200,
+		AvgTime:   51,
+		P50Time:   51,
+		P90Time:   91,
+		P99Time:   100,
+	})
+
+	assert.Equal(t, batchStats(nil), BatchStats{})
+}
+
+func TestBatch(t *testing.T) {
+	var running int32
+	var maxRunning int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		time.Sleep(time.Duration(10-id%10) * time.Millisecond)
+		if id == 13 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(w, "%s %d", r.Method, id)
+	}))
+	defer ts.Close()
+
+	items := []BatchItem{}
+	for i := 0; i < 20; i++ {
+		items = append(items, BatchItem{URL: fmt.Sprintf("%s/%d", ts.URL, i)})
+	}
+	items[5].Method = "POST"
+	items[6].URL = "http://127.0.0.1:1/"
+
+	ctx := context.Background()
+	results, stats := New().NewBatch(4).Do(ctx, items...)
+	assert.Equal(t, len(results), 20)
+	assert.True(t, atomic.LoadInt32(&maxRunning) <= 4)
+
+	for i, v := range results {
+		assert.Equal(t, v.Index, i)
+		if i == 6 {
+			assert.NotNil(t, v.Error)
+			continue
+		}
+		assert.Nil(t, v.Error)
+		text, err := v.Response.String()
+		assert.Nil(t, err)
+		if i == 5 {
+			assert.Equal(t, text, "POST 5")
+		} else {
+			assert.Equal(t, text, fmt.Sprintf("GET %d", i))
+		}
+	}
+
+	assert.Equal(t, stats.Total, 20)
+	assert.Equal(t, stats.Succeeded, 19)
+	assert.Equal(t, stats.Failed, 1)
+	assert.Equal(t, stats.Canceled, 0)
+	assert.True(t, stats.Time >= stats.MaxTime)
+
+	req := New().EnableHTTPError(true)
+	b := req.NewBatch(2)
+	b.FailFast = true
+	results, stats = b.Do(ctx, items[10:]...)
+	assert.True(t, IsHTTPError(results[3].Error))
+	assert.False(t, results[3].Canceled)
+	assert.Nil(t, results[0].Error)
+	assert.True(t, results[9].Canceled)
+	assert.Equal(t, stats.Failed, 1)
+	assert.True(t, stats.Canceled > 0)
+	assert.Equal(t, stats.Succeeded+stats.Failed+stats.Canceled, 10)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	results, stats = New().NewBatch(0).Do(cctx, items[:3]...)
+	assert.Equal(t, len(results), 3)
+	assert.Equal(t, stats.Canceled, 3)
+	assert.Equal(t, results[0].Error, context.Canceled)
+
+	results, stats = New().NewBatch(0).Do(ctx)
+	assert.Equal(t, len(results), 0)
+	assert.Equal(t, stats.Total, 0)
+
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer large.Close()
+
+	results, _ = New().SetDecodeLimit(10).NewBatch(1).Do(ctx, BatchItem{URL: large.URL})
+	assert.NotNil(t, results[0].Error)
+	assert.Contains(t, results[0].Error.Error(), "larger than")
+}
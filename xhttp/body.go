@@ -0,0 +1,398 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FormPart is multipart form part for upload with reader
+type FormPart struct {
+	Name        string
+	FileName    string
+	ContentType string
+	Reader      io.Reader
+}
+
+// Progress storing transfer progress
+type Progress struct {
+	Total   int64
+	Current int64
+	Rate    float64
+}
+
+// ProgressFunc is callback of transfer progress, total is -1 if unknown, rate is bytes per second
+type ProgressFunc func(Progress)
+
+// progressInterval is the min interval of calling progress callback
+const progressInterval = 100 * time.Millisecond
+
+// progressReader is reader reporting read progress
+type progressReader struct {
+	io.Reader
+	progress Progress
+	callback ProgressFunc
+	start    int64
+	startAt  time.Time
+	notifyAt time.Time
+}
+
+// newProgressReader returns a new progress reader, current is bytes already transferred,
+// which is not counted in the rate
+func newProgressReader(r io.Reader, current, total int64, callback ProgressFunc) *progressReader {
+	return &progressReader{
+		Reader: r,
+		progress: Progress{
+			Total:   total,
+			Current: current,
+		},
+		callback: callback,
+		start:    current,
+		startAt:  time.Now(),
+	}
+}
+
+// Read read data and report progress
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.Reader.Read(b)
+	p.progress.Current += int64(n)
+	if err == io.EOF || time.Since(p.notifyAt) >= progressInterval {
+		p.notify()
+	}
+
+	return
+}
+
+// Close close the reader if it is closer
+func (p *progressReader) Close() error {
+	if c, ok := p.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// notify call the progress callback
+func (p *progressReader) notify() {
+	p.notifyAt = time.Now()
+	if d := p.notifyAt.Sub(p.startAt).Seconds(); d > 0 {
+		p.progress.Rate = float64(p.progress.Current-p.start) / d
+	}
+
+	p.callback(p.progress)
+}
+
+// withProgress returns body with upload progress
+func withProgress(req *http.Request, progress ProgressFunc) {
+	if req.Body == nil || progress == nil {
+		return
+	}
+
+	total := req.ContentLength
+	if total <= 0 {
+		total = -1
+	}
+
+	req.Body = newProgressReader(req.Body, 0, total, progress)
+	if req.GetBody != nil {
+		getBody := req.GetBody
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return newProgressReader(body, 0, total, progress), nil
+		}
+	}
+}
+
+// setReaderBody set reader as request body, it is replayable if reader is io.Seeker
+func setReaderBody(req *http.Request, reader io.Reader) error {
+	req.ContentLength = readerSize(reader)
+	req.Body = ioutil.NopCloser(reader)
+
+	if seeker, ok := reader.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("xhttp: seek body reader failed: %s", err.Error())
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			_, err := seeker.Seek(offset, io.SeekStart)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(reader), nil
+		}
+	}
+
+	if req.ContentLength == 0 {
+		req.Body = http.NoBody
+		req.GetBody = nil
+	}
+
+	return nil
+}
+
+// setMultipartBody set multipart form as request body
+func setMultipartBody(req *http.Request, files FormFile, parts []FormPart, fields param) error {
+	for k, v := range files {
+		fd, err := os.Open(v)
+		if err != nil {
+			return fmt.Errorf("xhttp: open form file failed: %s", err.Error())
+		}
+		fi, err := fd.Stat()
+		fd.Close()
+		if err != nil {
+			return fmt.Errorf("xhttp: open form file failed: %s", err.Error())
+		}
+		parts = append(parts, FormPart{
+			Name:     k,
+			FileName: filepath.Base(v),
+			Reader:   &formFileReader{path: v, size: fi.Size()},
+		})
+	}
+
+	for _, v := range parts {
+		if v.Name == "" || v.Reader == nil {
+			return fmt.Errorf("xhttp: form part name and reader are required")
+		}
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+	newBody := func(offsets []int64) io.ReadCloser {
+		return &multipartBody{
+			write: func(w io.Writer) error {
+				bw := multipart.NewWriter(w)
+				_ = bw.SetBoundary(boundary)
+				return writeMultipart(bw, parts, offsets, fields)
+			},
+		}
+	}
+
+	offsets := make([]int64, len(parts))
+	replayable := true
+	for i, v := range parts {
+		if _, ok := v.Reader.(*formFileReader); ok {
+			continue
+		}
+		if seeker, ok := v.Reader.(io.Seeker); ok {
+			offset, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return fmt.Errorf("xhttp: seek form part reader failed: %s", err.Error())
+			}
+			offsets[i] = offset
+		} else {
+			replayable = false
+		}
+	}
+
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	req.ContentLength = multipartSize(boundary, parts, fields)
+	req.Body = newBody(nil)
+	if replayable {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return newBody(offsets), nil
+		}
+	}
+
+	return nil
+}
+
+// multipartBody is multipart body written by goroutine through pipe, the goroutine is started
+// at the first read, so no goroutine is left if the body is never sent
+type multipartBody struct {
+	mutex  sync.Mutex
+	write  func(io.Writer) error
+	reader *io.PipeReader
+	closed bool
+}
+
+// Read read the multipart body, the writing goroutine is started if not
+func (m *multipartBody) Read(b []byte) (int, error) {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+
+	if m.reader == nil {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(m.write(pw))
+		}()
+		m.reader = pr
+	}
+
+	reader := m.reader
+	m.mutex.Unlock()
+
+	return reader.Read(b)
+}
+
+// Close close the body, the writing goroutine is stopped if started
+func (m *multipartBody) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.closed = true
+	if m.reader != nil {
+		return m.reader.Close()
+	}
+
+	return nil
+}
+
+// writeMultipart write form parts and fields to multipart writer
+func writeMultipart(bw *multipart.Writer, parts []FormPart, offsets []int64, fields param) error {
+	for i, v := range parts {
+		var fd *os.File
+		reader := v.Reader
+		if f, ok := reader.(*formFileReader); ok {
+			var err error
+			fd, err = os.Open(f.path)
+			if err != nil {
+				return fmt.Errorf("xhttp: open form file failed: %s", err.Error())
+			}
+			reader = fd
+		} else if seeker, ok := reader.(io.Seeker); ok && offsets != nil {
+			_, err := seeker.Seek(offsets[i], io.SeekStart)
+			if err != nil {
+				return fmt.Errorf("xhttp: seek form part reader failed: %s", err.Error())
+			}
+		}
+		fw, err := bw.CreatePart(formPartHeader(v))
+		if err == nil {
+			_, err = io.Copy(fw, reader)
+		}
+		if fd != nil {
+			fd.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("xhttp: read form part %s failed: %s", v.Name, err.Error())
+		}
+	}
+
+	for k, v := range fields.Values {
+		for _, vv := range v {
+			err := bw.WriteField(k, vv)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Close()
+}
+
+// multipartSize returns the size of multipart body, -1 if unknown
+func multipartSize(boundary string, parts []FormPart, fields param) int64 {
+	var buf bytes.Buffer
+	bw := multipart.NewWriter(&buf)
+	_ = bw.SetBoundary(boundary)
+
+	size := int64(0)
+	for _, v := range parts {
+		n := readerSize(v.Reader)
+		if n < 0 {
+			return -1
+		}
+		size += n
+		_, _ = bw.CreatePart(formPartHeader(v))
+	}
+
+	for k, v := range fields.Values {
+		for _, vv := range v {
+			_ = bw.WriteField(k, vv)
+		}
+	}
+
+	_ = bw.Close()
+
+	return size + int64(buf.Len())
+}
+
+// formPartHeader returns the mime header of form part
+func formPartHeader(part FormPart) textproto.MIMEHeader {
+	escape := strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace
+
+	disposition := fmt.Sprintf(`form-data; name="%s"`, escape(part.Name))
+	if part.FileName != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, escape(part.FileName))
+	}
+
+	contentType := part.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", disposition)
+	h.Set("Content-Type", contentType)
+
+	return h
+}
+
+// readerSize returns the unread size of reader, -1 if unknown
+func readerSize(reader io.Reader) int64 {
+	switch v := reader.(type) {
+	case *bytes.Buffer:
+		return int64(v.Len())
+	case *bytes.Reader:
+		return int64(v.Len())
+	case *strings.Reader:
+		return int64(v.Len())
+	case *formFileReader:
+		return v.size
+	case *os.File:
+		fi, err := v.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return fi.Size() - offset
+	default:
+		return -1
+	}
+}
+
+// formFileReader is reader of form file, the file is opened when writing multipart
+type formFileReader struct {
+	path string
+	size int64
+}
+
+// Read always returns EOF, the file is read by writeMultipart
+func (f *formFileReader) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
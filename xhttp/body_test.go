@@ -0,0 +1,231 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xjson"
+)
+
+type errorReader struct{}
+
+func (r errorReader) Read(b []byte) (int, error) {
+	return 0, fmt.Errorf("read failed")
+}
+
+func TestReaderBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d|%s|%s|%s", r.ContentLength, r.Header.Get("Content-Type"), r.TransferEncoding, body)
+	}))
+	defer ts.Close()
+
+	req := New()
+	ctx := context.Background()
+
+	// sized reader
+	rsp, err := req.Do(ctx, "POST", ts.URL, strings.NewReader("hello world"))
+	assert.Nil(t, err)
+	defer rsp.Close()
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "11|application/octet-stream|[]|hello world")
+
+	// file reader
+	fd, err := os.Open("../go.mod")
+	assert.Nil(t, err)
+	defer fd.Close()
+	rsp, err = req.Do(ctx, "PUT", ts.URL, fd, Header{"Content-Type": "text/plain"})
+	assert.Nil(t, err)
+	defer rsp.Close()
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Contains(t, text, "|text/plain|[]|module github.com/likexian/gokit")
+
+	// unknown size reader is chunked
+	rsp, err = req.Do(ctx, "POST", ts.URL, io.LimitReader(strings.NewReader("hello world"), 5))
+	assert.Nil(t, err)
+	defer rsp.Close()
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "-1|application/octet-stream|[chunked]|hello")
+
+	// reader error is returned
+	_, err = req.Do(ctx, "POST", ts.URL, errorReader{})
+	assert.NotNil(t, err)
+}
+
+func TestFormPart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		result := map[string]interface{}{
+			"length": r.ContentLength,
+			"form":   r.MultipartForm.Value,
+		}
+		for k, v := range r.MultipartForm.File {
+			fd, _ := v[0].Open()
+			body, _ := ioutil.ReadAll(fd)
+			fd.Close()
+			result[k] = []string{v[0].Filename, v[0].Header.Get("Content-Type"), string(body)}
+		}
+		text, _ := xjson.Dumps(result)
+		fmt.Fprint(w, text)
+	}))
+	defer ts.Close()
+
+	req := New()
+	ctx := context.Background()
+
+	// reader parts with known size
+	rsp, err := req.Do(ctx, "POST", ts.URL, FormParam{"k": "v"},
+		FormPart{Name: "a", FileName: "a.txt", ContentType: "text/plain", Reader: strings.NewReader("aaa")},
+		[]FormPart{{Name: "b", FileName: "b.bin", Reader: bytes.NewReader([]byte("bbb"))}},
+		FormFile{"c": "../go.mod"})
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 200)
+	assert.Gt(t, rsp.Response.Request.ContentLength, int64(0))
+	json, err := rsp.Json()
+	assert.Nil(t, err)
+	assert.Equal(t, json.Get("length").MustInt64(0), rsp.Response.Request.ContentLength)
+	assert.Equal(t, json.Get("form.k").MustStringArray(), []string{"v"})
+	assert.Equal(t, json.Get("a").MustStringArray(), []string{"a.txt", "text/plain", "aaa"})
+	assert.Equal(t, json.Get("b").MustStringArray(), []string{"b.bin", "application/octet-stream", "bbb"})
+	assert.Equal(t, json.Get("c.0").MustString(""), "go.mod")
+	assert.Contains(t, json.Get("c.2").MustString(""), "module github.com/likexian/gokit")
+
+	// reader part with unknown size
+	rsp, err = req.Do(ctx, "POST", ts.URL, FormPart{Name: "a", FileName: "a.txt", Reader: io.LimitReader(strings.NewReader("aaa"), 2)})
+	assert.Nil(t, err)
+	defer rsp.Close()
+	json, err = rsp.Json()
+	assert.Nil(t, err)
+	assert.Equal(t, json.Get("length").MustInt64(0), int64(-1))
+	assert.Equal(t, json.Get("a.2").MustString(""), "aa")
+
+	// part error is returned
+	_, err = req.Do(ctx, "POST", ts.URL, FormPart{Name: "a", Reader: errorReader{}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "read form part a failed")
+
+	_, err = req.Do(ctx, "POST", ts.URL, FormPart{Name: "a"})
+	assert.NotNil(t, err)
+}
+
+func TestFormPartNotSent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	b := NewBreaker()
+	b.ConsecutiveFailures = 1
+	b.CoolDown = time.Minute
+
+	req := New().SetBreaker(b)
+	ctx := context.Background()
+
+	_, err := req.Post(ctx, ts.URL, FormPart{Name: "a", Reader: strings.NewReader("aaa")})
+	assert.Nil(t, err)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		_, err = req.Post(ctx, ts.URL, FormPart{Name: "a", Reader: strings.NewReader("aaa")},
+			FormFile{"b": "../go.mod"})
+		assert.True(t, IsBreakerError(err))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, runtime.NumGoroutine() <= before+5, before, runtime.NumGoroutine())
+
+	body := &multipartBody{write: func(w io.Writer) error {
+		_, err := w.Write([]byte(strings.Repeat("x", 100)))
+		return err
+	}}
+	buf := make([]byte, 10)
+	n, err := body.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, n, 10)
+	assert.Nil(t, body.Close())
+	_, err = body.Read(buf)
+	assert.Equal(t, err, io.ErrClosedPipe)
+}
+
+func TestProgressRate(t *testing.T) {
+	last := Progress{}
+	p := newProgressReader(strings.NewReader("0123456789"), 1<<30, -1, func(v Progress) {
+		last = v
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	_, err := ioutil.ReadAll(p)
+	assert.Nil(t, err)
+	assert.Equal(t, last.Current, int64(1<<30+10))
+	assert.Gt(t, last.Rate, float64(0))
+	assert.True(t, last.Rate <= 1000, last.Rate)
+}
+
+func TestUploadProgress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer ts.Close()
+
+	req := New()
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	last := Progress{}
+	rsp, err := req.Do(ctx, "POST", ts.URL, bytes.NewReader(data), ProgressFunc(func(p Progress) {
+		last = p
+	}))
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, last.Total, int64(len(data)))
+	assert.Equal(t, last.Current, int64(len(data)))
+	assert.Gt(t, last.Rate, float64(0))
+
+	last = Progress{}
+	rsp, err = req.Do(ctx, "POST", ts.URL, FormPart{Name: "a", Reader: io.LimitReader(bytes.NewReader(data), 1024)},
+		ProgressFunc(func(p Progress) {
+			last = p
+		}))
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, last.Total, int64(-1))
+	assert.Gt(t, last.Current, int64(1024))
+}
@@ -0,0 +1,282 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState is circuit breaker state
+type BreakerState int
+
+// Circuit breaker states
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// BreakerError is error returned when request is rejected by circuit breaker
+type BreakerError struct {
+	Host  string
+	State BreakerState
+}
+
+// Breaker is per host circuit breaker,
+// it opens when consecutive failures or failure ratio in window reach the threshold,
+// after cool down it turns to half-open and allows probe requests, closes when all probes succeed
+type Breaker struct {
+	ConsecutiveFailures int
+	FailureRatio        float64
+	MinRequests         int
+	Window              time.Duration
+	CoolDown            time.Duration
+	HalfOpenProbes      int
+	IsFailure           func(*http.Response, error) bool
+	OnStateChange       func(host string, from, to BreakerState)
+	sync.Mutex
+	hosts map[string]*breakerHost
+}
+
+// breakerHost storing circuit breaker counting of host
+type breakerHost struct {
+	state       BreakerState
+	generation  int64
+	openedAt    time.Time
+	windowAt    time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+	successes   int
+}
+
+// String returns name of breaker state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Error returns error message
+func (e *BreakerError) Error() string {
+	return fmt.Sprintf("xhttp: circuit breaker is %s for %s", e.State, e.Host)
+}
+
+// IsBreakerError returns if error is rejected by circuit breaker
+func IsBreakerError(err error) bool {
+	_, ok := err.(*BreakerError)
+	return ok
+}
+
+// NewBreaker returns a new circuit breaker with default setting,
+// opens after 5 consecutive failures or 50% failures of at least 10 requests in 60 seconds,
+// cool down for 30 seconds and probe with 1 request, failure is error or status code >= 500
+func NewBreaker() *Breaker {
+	return &Breaker{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         10,
+		Window:              60 * time.Second,
+		CoolDown:            30 * time.Second,
+		HalfOpenProbes:      1,
+		hosts:               map[string]*breakerHost{},
+	}
+}
+
+// SetBreaker set circuit breaker of request, nil is disable (default),
+// a breaker can be shared by requests for sharing the state of hosts
+func (r *Request) SetBreaker(b *Breaker) *Request {
+	r.Breaker = b
+	return r
+}
+
+// State returns the circuit breaker state of host
+func (b *Breaker) State(host string) BreakerState {
+	b.Lock()
+	defer b.Unlock()
+
+	h, ok := b.hosts[host]
+	if !ok {
+		return BreakerClosed
+	}
+
+	if h.state == BreakerOpen && time.Since(h.openedAt) >= b.CoolDown {
+		return BreakerHalfOpen
+	}
+
+	return h.state
+}
+
+// Reset reset the circuit breaker state of all hosts to closed
+func (b *Breaker) Reset() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.hosts = map[string]*breakerHost{}
+}
+
+// allow returns the generation if request to host is allowed, else returns *BreakerError
+func (b *Breaker) allow(host string) (generation int64, err error) {
+	b.Lock()
+	from, to := BreakerClosed, BreakerClosed
+	defer func() {
+		b.Unlock()
+		b.notify(host, from, to)
+	}()
+
+	h := b.getHost(host)
+	now := time.Now()
+
+	if h.state == BreakerOpen {
+		if now.Sub(h.openedAt) < b.CoolDown {
+			return 0, &BreakerError{Host: host, State: BreakerOpen}
+		}
+		from, to = h.state, BreakerHalfOpen
+		b.setState(h, BreakerHalfOpen, now)
+	}
+
+	if h.state == BreakerHalfOpen {
+		if h.probes >= b.maxProbes() {
+			return 0, &BreakerError{Host: host, State: BreakerHalfOpen}
+		}
+		h.probes++
+	}
+
+	if h.state == BreakerClosed && b.Window > 0 && now.Sub(h.windowAt) >= b.Window {
+		h.windowAt = now
+		h.requests, h.failures = 0, 0
+	}
+
+	return h.generation, nil
+}
+
+// done record the result of request allowed at generation, ignore is for canceled request
+func (b *Breaker) done(host string, generation int64, rsp *http.Response, err error, ignore bool) {
+	failed := false
+	if !ignore {
+		if b.IsFailure != nil {
+			failed = b.IsFailure(rsp, err)
+		} else {
+			failed = err != nil || rsp.StatusCode >= http.StatusInternalServerError
+		}
+	}
+
+	b.Lock()
+	from, to := BreakerClosed, BreakerClosed
+	defer func() {
+		b.Unlock()
+		b.notify(host, from, to)
+	}()
+
+	h := b.getHost(host)
+	if h.generation != generation {
+		return
+	}
+
+	now := time.Now()
+	switch h.state {
+	case BreakerHalfOpen:
+		h.probes--
+		if ignore {
+			return
+		}
+		if failed {
+			from, to = h.state, BreakerOpen
+			b.setState(h, BreakerOpen, now)
+			return
+		}
+		h.successes++
+		if h.successes >= b.maxProbes() {
+			from, to = h.state, BreakerClosed
+			b.setState(h, BreakerClosed, now)
+		}
+	case BreakerClosed:
+		if ignore {
+			return
+		}
+		h.requests++
+		if !failed {
+			h.consecutive = 0
+			return
+		}
+		h.failures++
+		h.consecutive++
+		if (b.ConsecutiveFailures > 0 && h.consecutive >= b.ConsecutiveFailures) ||
+			(b.FailureRatio > 0 && h.requests >= b.MinRequests &&
+				float64(h.failures)/float64(h.requests) >= b.FailureRatio) {
+			from, to = h.state, BreakerOpen
+			b.setState(h, BreakerOpen, now)
+		}
+	}
+}
+
+// getHost returns counting of host, creates it if not exists
+func (b *Breaker) getHost(host string) *breakerHost {
+	if b.hosts == nil {
+		b.hosts = map[string]*breakerHost{}
+	}
+
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &breakerHost{state: BreakerClosed, windowAt: time.Now()}
+		b.hosts[host] = h
+	}
+
+	return h
+}
+
+// setState set state of host and reset the counting
+func (b *Breaker) setState(h *breakerHost, state BreakerState, now time.Time) {
+	h.state = state
+	h.generation++
+	h.windowAt = now
+	h.requests, h.failures, h.consecutive = 0, 0, 0
+	h.probes, h.successes = 0, 0
+	if state == BreakerOpen {
+		h.openedAt = now
+	}
+}
+
+// maxProbes returns max probe requests in half-open state
+func (b *Breaker) maxProbes() int {
+	if b.HalfOpenProbes < 1 {
+		return 1
+	}
+
+	return b.HalfOpenProbes
+}
+
+// notify call the state change callback if state is changed
+func (b *Breaker) notify(host string, from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestBreakerState(t *testing.T) {
+	assert.Equal(t, BreakerClosed.String(), "closed")
+	assert.Equal(t, BreakerOpen.String(), "open")
+	assert.Equal(t, BreakerHalfOpen.String(), "half-open")
+	assert.Equal(t, BreakerState(100).String(), "unknown")
+
+	err := &BreakerError{Host: "likexian.com", State: BreakerOpen}
+	assert.Equal(t, err.Error(), "xhttp: circuit breaker is open for likexian.com")
+	assert.True(t, IsBreakerError(err))
+	assert.False(t, IsBreakerError(fmt.Errorf("xhttp: error")))
+}
+
+func TestBreakerConsecutive(t *testing.T) {
+	var mutex sync.Mutex
+	changes := []string{}
+
+	b := NewBreaker()
+	b.ConsecutiveFailures = 3
+	b.CoolDown = 50 * time.Millisecond
+	b.OnStateChange = func(host string, from, to BreakerState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, fmt.Sprintf("%s:%s-%s", host, from, to))
+	}
+
+	ok := &http.Response{StatusCode: http.StatusOK}
+	bad := &http.Response{StatusCode: http.StatusServiceUnavailable}
+
+	for i := 0; i < 2; i++ {
+		g, err := b.allow("a")
+		assert.Nil(t, err)
+		b.done("a", g, bad, nil, false)
+	}
+
+	g, err := b.allow("a")
+	assert.Nil(t, err)
+	b.done("a", g, ok, nil, false)
+	assert.Equal(t, b.State("a"), BreakerClosed)
+
+	for i := 0; i < 3; i++ {
+		g, err := b.allow("a")
+		assert.Nil(t, err)
+		b.done("a", g, nil, fmt.Errorf("connect failed"), false)
+	}
+
+	assert.Equal(t, b.State("a"), BreakerOpen)
+	assert.Equal(t, b.State("b"), BreakerClosed)
+
+	_, err = b.allow("a")
+	assert.True(t, IsBreakerError(err))
+	assert.Equal(t, err.(*BreakerError).State, BreakerOpen)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, b.State("a"), BreakerHalfOpen)
+
+	g, err = b.allow("a")
+	assert.Nil(t, err)
+	_, err = b.allow("a")
+	assert.Equal(t, err.(*BreakerError).State, BreakerHalfOpen)
+	b.done("a", g, bad, nil, false)
+	assert.Equal(t, b.State("a"), BreakerOpen)
+
+	time.Sleep(60 * time.Millisecond)
+	g, err = b.allow("a")
+	assert.Nil(t, err)
+	b.done("a", g, nil, context.Canceled, true)
+	g, err = b.allow("a")
+	assert.Nil(t, err)
+	b.done("a", g, ok, nil, false)
+	assert.Equal(t, b.State("a"), BreakerClosed)
+
+	mutex.Lock()
+	assert.Equal(t, changes, []string{"a:closed-open", "a:open-half-open", "a:half-open-open",
+		"a:open-half-open", "a:half-open-closed"})
+	mutex.Unlock()
+
+	b.Reset()
+	assert.Equal(t, b.State("a"), BreakerClosed)
+}
+
+func TestBreakerRatio(t *testing.T) {
+	b := NewBreaker()
+	b.ConsecutiveFailures = 0
+	b.FailureRatio = 0.5
+	b.MinRequests = 4
+	b.Window = 50 * time.Millisecond
+
+	ok := &http.Response{StatusCode: http.StatusOK}
+	bad := &http.Response{StatusCode: http.StatusInternalServerError}
+
+	for _, v := range []*http.Response{bad, ok, bad} {
+		g, err := b.allow("a")
+		assert.Nil(t, err)
+		b.done("a", g, v, nil, false)
+	}
+	assert.Equal(t, b.State("a"), BreakerClosed)
+
+	time.Sleep(60 * time.Millisecond)
+	for _, v := range []*http.Response{bad, ok, ok} {
+		g, err := b.allow("a")
+		assert.Nil(t, err)
+		b.done("a", g, v, nil, false)
+	}
+	assert.Equal(t, b.State("a"), BreakerClosed)
+
+	g, err := b.allow("a")
+	assert.Nil(t, err)
+	b.done("a", g, bad, nil, false)
+	assert.Equal(t, b.State("a"), BreakerOpen)
+
+	b.Reset()
+	b.IsFailure = func(rsp *http.Response, err error) bool {
+		return err != nil || rsp.StatusCode == http.StatusTooManyRequests
+	}
+	for i := 0; i < 4; i++ {
+		g, err := b.allow("a")
+		assert.Nil(t, err)
+		b.done("a", g, bad, nil, false)
+	}
+	assert.Equal(t, b.State("a"), BreakerClosed)
+}
+
+func TestSetBreaker(t *testing.T) {
+	var called int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&called, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	b := NewBreaker()
+	b.ConsecutiveFailures = 2
+	b.CoolDown = time.Minute
+
+	policy := NewBackoff()
+	policy.MinSleep = time.Millisecond
+	req := New().SetBreaker(b).SetRetries(5, policy)
+	assert.Equal(t, req.Clone().Breaker, b)
+
+	_, err := req.Get(context.Background(), ts.URL)
+	assert.True(t, IsBreakerError(err))
+	assert.Equal(t, atomic.LoadInt32(&called), int32(2))
+
+	u, _ := url.Parse(ts.URL)
+	assert.Equal(t, b.State(u.Host), BreakerOpen)
+
+	rsp, err := req.Get(context.Background(), ts.URL)
+	assert.True(t, IsBreakerError(err))
+	assert.Equal(t, len(rsp.Tracing.Attempts), 1)
+	assert.Equal(t, rsp.Tracing.Attempts[0].Error, err.Error())
+	assert.Equal(t, atomic.LoadInt32(&called), int32(2))
+}
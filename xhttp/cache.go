@@ -0,0 +1,375 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xcache"
+	"github.com/likexian/gokit/xhash"
+)
+
+// Cache status set to Tracing.CacheStatus
+const (
+	CacheMiss        = "MISS"
+	CacheHit         = "HIT"
+	CacheRevalidated = "REVALIDATED"
+)
+
+// cacheStaleTTL is seconds of stale entry with validator kept for revalidation
+const cacheStaleTTL = 86400
+
+// cacheStatusCode is status code cacheable by default, RFC 7231 section 6.1
+var cacheStatusCode = []int{200, 203, 204, 300, 301, 404, 405, 410, 414, 501}
+
+// cacheEntry storing serialised response in cache
+type cacheEntry struct {
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	RequestTime  int64       `json:"request_time"`
+	ResponseTime int64       `json:"response_time"`
+}
+
+// SetCache set cache backend of request, default is the memory cache created by EnableCache,
+// it is shared by the requests cloned after it is set
+func (r *Request) SetCache(cache xcache.Cachex) *Request {
+	r.Caching.Cache = cache
+	return r
+}
+
+// cacheLookup lookup response from cache, returns the cached entry and it is fresh or not,
+// if entry is stale then conditional headers are set for revalidation
+func (r *Request) cacheLookup(req *http.Request, s *Response, ttl int64) (*cacheEntry, bool) {
+	s.Tracing.CacheStatus = CacheMiss
+
+	cache := r.Caching.Cache
+	entry := loadCacheEntry(cache.Get(cacheEntryKey(cache, s.CacheKey, req.Header)))
+	if entry == nil {
+		return nil, false
+	}
+
+	cc := parseCacheControl(req.Header)
+	_, noCache := cc["no-cache"]
+	if !noCache && len(cc) == 0 && strings.Contains(req.Header.Get("Pragma"), "no-cache") {
+		noCache = true
+	}
+	if v, ok := cc["max-age"]; ok && v == "0" {
+		noCache = true
+	}
+
+	now := time.Now().Unix()
+	if !noCache && entry.lifetime(ttl) > entry.age(now) {
+		s.Tracing.CacheStatus = CacheHit
+		s.Response = entry.response(req, now)
+		return entry, true
+	}
+
+	etag := entry.Header.Get("Etag")
+	modified := entry.Header.Get("Last-Modified")
+	if etag == "" && modified == "" {
+		return nil, false
+	}
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+
+	return entry, false
+}
+
+// cacheUpdate update cache by response, returns error if reading response body failed
+func (r *Request) cacheUpdate(req *http.Request, s *Response, entry *cacheEntry, ttl, requestTime int64) error {
+	now := time.Now().Unix()
+	cache := r.Caching.Cache
+
+	if entry != nil && s.Response.StatusCode == http.StatusNotModified {
+		discardBody(s.Response)
+		for k, v := range s.Response.Header {
+			if !assert.IsContains([]string{"Content-Length", "Content-Encoding", "Transfer-Encoding"}, k) {
+				entry.Header[k] = v
+			}
+		}
+		entry.RequestTime = requestTime
+		entry.ResponseTime = now
+		s.Tracing.CacheStatus = CacheRevalidated
+		s.Response = entry.response(req, now)
+		r.cacheStore(req, cache, s.CacheKey, entry, ttl)
+		return nil
+	}
+
+	if !isCacheable(s.Response) {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(s.Response.Body)
+	s.Response.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	s.Response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	entry = &cacheEntry{
+		StatusCode:   s.Response.StatusCode,
+		Header:       cloneHeader(s.Response.Header),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: now,
+	}
+
+	r.cacheStore(req, cache, s.CacheKey, entry, ttl)
+
+	return nil
+}
+
+// cacheStore save entry to cache
+func (r *Request) cacheStore(req *http.Request, cache xcache.Cachex, key string, entry *cacheEntry, ttl int64) {
+	expire := entry.lifetime(ttl) - entry.age(entry.ResponseTime)
+	if entry.Header.Get("Etag") != "" || entry.Header.Get("Last-Modified") != "" {
+		expire += cacheStaleTTL
+	}
+
+	if expire <= 0 {
+		return
+	}
+
+	vary := parseVary(entry.Header)
+	text, err := json.Marshal(vary)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	_ = cache.Set(key, string(text), expire)
+	_ = cache.Set(varyKey(key, vary, req.Header), string(data), expire)
+}
+
+// cacheEntryKey returns the key of entry with the request vary headers
+func cacheEntryKey(cache xcache.Cachex, key string, header http.Header) string {
+	text, ok := cache.Get(key).(string)
+	if !ok {
+		return ""
+	}
+
+	vary := []string{}
+	if json.Unmarshal([]byte(text), &vary) != nil {
+		return ""
+	}
+
+	return varyKey(key, vary, header)
+}
+
+// varyKey returns the key of entry with vary headers
+func varyKey(key string, vary []string, header http.Header) string {
+	values := []interface{}{key}
+	for _, v := range vary {
+		values = append(values, v, strings.Join(header[v], ","))
+	}
+
+	return xhash.Sha1(values...).Hex()
+}
+
+// loadCacheEntry returns entry unserialised from cache value
+func loadCacheEntry(v interface{}) *cacheEntry {
+	text, ok := v.(string)
+	if !ok {
+		return nil
+	}
+
+	entry := &cacheEntry{}
+	if json.Unmarshal([]byte(text), entry) != nil {
+		return nil
+	}
+
+	if entry.Header == nil {
+		entry.Header = http.Header{}
+	}
+
+	return entry
+}
+
+// response returns http response of entry
+func (e *cacheEntry) response(req *http.Request, now int64) *http.Response {
+	header := cloneHeader(e.Header)
+	header.Set("Age", fmt.Sprintf("%d", e.age(now)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// date returns the Date header as unix time
+func (e *cacheEntry) date() int64 {
+	t, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		return e.ResponseTime
+	}
+
+	return t.Unix()
+}
+
+// age returns the current age of entry, RFC 7234 section 4.2.3
+func (e *cacheEntry) age(now int64) int64 {
+	apparent := e.ResponseTime - e.date()
+	if apparent < 0 {
+		apparent = 0
+	}
+
+	value, _ := strconv.ParseInt(e.Header.Get("Age"), 10, 64)
+	corrected := value + e.ResponseTime - e.RequestTime
+	if corrected > apparent {
+		apparent = corrected
+	}
+
+	return apparent + now - e.ResponseTime
+}
+
+// lifetime returns the freshness lifetime of entry, RFC 7234 section 4.2.1
+// ttl is used when no explicit expiration time, it is used as heuristic freshness
+func (e *cacheEntry) lifetime(ttl int64) int64 {
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+
+	if v, ok := cc["max-age"]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+
+	if v, ok := e.Header["Expires"]; ok {
+		t, err := http.ParseTime(strings.Join(v, ""))
+		if err != nil {
+			return 0
+		}
+		return t.Unix() - e.date()
+	}
+
+	if ttl > 0 {
+		return ttl
+	}
+
+	t, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	if err == nil && t.Unix() < e.date() {
+		return (e.date() - t.Unix()) / 10
+	}
+
+	return 0
+}
+
+// isCacheable returns response is cacheable, RFC 7234 section 3
+func isCacheable(rsp *http.Response) bool {
+	cc := parseCacheControl(rsp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+
+	if assert.IsContains(parseVary(rsp.Header), "*") {
+		return false
+	}
+
+	if assert.IsContains(cacheStatusCode, rsp.StatusCode) {
+		return true
+	}
+
+	_, ok := cc["max-age"]
+
+	return ok || rsp.Header.Get("Expires") != ""
+}
+
+// isNoStore returns request is not allowed to use cache
+func isNoStore(header http.Header) bool {
+	_, ok := parseCacheControl(header)["no-store"]
+	return ok
+}
+
+// parseCacheControl returns the Cache-Control directives
+func parseCacheControl(header http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range header["Cache-Control"] {
+		for _, vv := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(vv), "=", 2)
+			k := strings.ToLower(strings.TrimSpace(kv[0]))
+			if k == "" {
+				continue
+			}
+			cc[k] = ""
+			if len(kv) == 2 {
+				cc[k] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			}
+		}
+	}
+
+	return cc
+}
+
+// parseVary returns the sorted canonical Vary headers
+func parseVary(header http.Header) []string {
+	vary := []string{}
+	for _, v := range header["Vary"] {
+		for _, vv := range strings.Split(v, ",") {
+			vv = strings.TrimSpace(vv)
+			if vv != "" && !assert.IsContains(vary, http.CanonicalHeaderKey(vv)) {
+				vary = append(vary, http.CanonicalHeaderKey(vv))
+			}
+		}
+	}
+
+	sort.Strings(vary)
+
+	return vary
+}
+
+// cloneHeader returns a copy of http header
+func cloneHeader(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+	for k, v := range header {
+		h[k] = append([]string(nil), v...)
+	}
+
+	return h
+}
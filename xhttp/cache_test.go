@@ -0,0 +1,173 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xcache"
+)
+
+func TestHttpCache(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/expired":
+			w.Header().Set("Expires", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(w, "%d", n)
+	}))
+	defer ts.Close()
+
+	cache := xcache.New(xcache.MemoryCache)
+	req := New().SetCache(cache).EnableCache("GET", 0)
+	ctx := context.Background()
+
+	get := func(path string, args ...interface{}) (string, string) {
+		req := req
+		if len(args) > 0 {
+			req = New().SetCache(cache).EnableCache("GET", 0)
+		}
+		rsp, err := req.Do(ctx, "GET", ts.URL+path, args...)
+		assert.Nil(t, err)
+		defer rsp.Close()
+		text, err := rsp.String()
+		assert.Nil(t, err)
+		return text, rsp.Tracing.CacheStatus
+	}
+
+	// fresh by max-age
+	text, status := get("/max-age")
+	assert.Equal(t, status, CacheMiss)
+	newText, status := get("/max-age")
+	assert.Equal(t, status, CacheHit)
+	assert.Equal(t, newText, text)
+
+	// request no-cache requires revalidation
+	newText, status = get("/max-age", Header{"Cache-Control": "no-cache"})
+	assert.Equal(t, status, CacheMiss)
+	assert.NotEqual(t, newText, text)
+
+	// request no-store skips cache
+	_, status = get("/max-age", Header{"Cache-Control": "no-store"})
+	assert.Equal(t, status, "")
+
+	// response no-store is not cached
+	text, _ = get("/no-store")
+	newText, status = get("/no-store")
+	assert.Equal(t, status, CacheMiss)
+	assert.NotEqual(t, newText, text)
+
+	// expired response is not cached
+	text, _ = get("/expired")
+	newText, status = get("/expired")
+	assert.Equal(t, status, CacheMiss)
+	assert.NotEqual(t, newText, text)
+
+	// error status without explicit freshness is not cached
+	text, _ = get("/error")
+	newText, _ = get("/error")
+	assert.NotEqual(t, newText, text)
+
+	// revalidate by etag
+	text, status = get("/etag")
+	assert.Equal(t, status, CacheMiss)
+	newText, status = get("/etag")
+	assert.Equal(t, status, CacheRevalidated)
+	assert.Equal(t, newText, text)
+
+	// cache vary by request header
+	en, _ := get("/vary", Header{"Accept-Language": "en"})
+	zh, _ := get("/vary", Header{"Accept-Language": "zh"})
+	assert.NotEqual(t, en, zh)
+	text, status = get("/vary", Header{"Accept-Language": "en"})
+	assert.Equal(t, status, CacheHit)
+	assert.Equal(t, text, en)
+	text, status = get("/vary", Header{"Accept-Language": "zh"})
+	assert.Equal(t, status, CacheHit)
+	assert.Equal(t, text, zh)
+
+	// cache backend is per request
+	rsp, err := New().EnableCache("GET", 0).Get(ctx, ts.URL+"/max-age")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.Tracing.CacheStatus, CacheMiss)
+
+	// cache is stored serialised
+	_, ok := cache.Get(rsp.CacheKey).(string)
+	assert.True(t, ok)
+}
+
+func TestCacheEntry(t *testing.T) {
+	now := time.Now().Unix()
+	date := time.Unix(now-100, 0).UTC().Format(http.TimeFormat)
+
+	entry := &cacheEntry{
+		Header:       http.Header{"Date": []string{date}},
+		RequestTime:  now - 10,
+		ResponseTime: now - 5,
+	}
+	assert.Equal(t, entry.age(now), int64(100))
+
+	entry.Header.Set("Age", "200")
+	assert.Equal(t, entry.age(now), int64(210))
+	assert.Equal(t, entry.lifetime(0), int64(0))
+	assert.Equal(t, entry.lifetime(300), int64(300))
+
+	entry.Header.Set("Last-Modified", time.Unix(now-1100, 0).UTC().Format(http.TimeFormat))
+	assert.Equal(t, entry.lifetime(0), int64(100))
+
+	entry.Header.Set("Expires", time.Unix(now+100, 0).UTC().Format(http.TimeFormat))
+	assert.Equal(t, entry.lifetime(300), int64(200))
+
+	entry.Header.Set("Cache-Control", "public, max-age=30")
+	assert.Equal(t, entry.lifetime(300), int64(30))
+
+	entry.Header.Set("Cache-Control", "max-age=30, no-cache")
+	assert.Equal(t, entry.lifetime(300), int64(0))
+
+	assert.Equal(t, parseVary(http.Header{"Vary": []string{"accept-language, Accept", "Accept"}}), []string{"Accept", "Accept-Language"})
+	assert.Equal(t, parseCacheControl(http.Header{"Cache-Control": []string{`max-age="10", No-Cache`}}),
+		map[string]string{"max-age": "10", "no-cache": ""})
+}
@@ -0,0 +1,342 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/likexian/gokit/xfile"
+)
+
+// Cassette modes
+const (
+	// CassetteRecord is sending request and recording, the exists cassette is overwritten
+	CassetteRecord = iota
+	// CassetteReplay is replaying recorded response only, not recorded request returns error
+	CassetteReplay
+	// CassetteAuto is replaying if recorded, else sending request and recording
+	CassetteAuto
+)
+
+// redactedValue is value of redacted secret
+const redactedValue = "REDACTED"
+
+// Cassette is recording request and response to file and replaying them offline,
+// request is matched by method and url, MatchBody and MatchHeaders are for matching more,
+// headers in Redact are redacted in file and matched by presence
+type Cassette struct {
+	Path         string         `json:"-"`
+	Mode         int            `json:"-"`
+	MatchBody    bool           `json:"-"`
+	MatchHeaders []string       `json:"-"`
+	Redact       []string       `json:"-"`
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions"`
+	mutex        sync.Mutex
+	played       map[*Interaction]bool
+}
+
+// Interaction is a recorded request and response
+type Interaction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest is recorded request
+type CassetteRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+	Base64 bool        `json:"base64,omitempty"`
+}
+
+// CassetteResponse is recorded response
+type CassetteResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+	Base64     bool        `json:"base64,omitempty"`
+}
+
+// NewCassette returns a new cassette of file, the file is loaded if mode is replay or auto,
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie headers are redacted by default
+func NewCassette(fpath string, mode int) (*Cassette, error) {
+	c := &Cassette{
+		Path:         fpath,
+		Mode:         mode,
+		Redact:       []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+		Version:      1,
+		Interactions: []*Interaction{},
+		played:       map[*Interaction]bool{},
+	}
+
+	if mode == CassetteRecord {
+		return c, nil
+	}
+
+	if mode == CassetteAuto && !xfile.Exists(fpath) {
+		return c, nil
+	}
+
+	data, err := xfile.Read(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("xhttp: read cassette failed: %s", err.Error())
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("xhttp: load cassette failed: %s", err.Error())
+	}
+
+	return c, nil
+}
+
+// SetCassette set cassette of request, it is added as interceptor
+func (r *Request) SetCassette(c *Cassette) *Request {
+	return r.AddInterceptor(c.Interceptor())
+}
+
+// Interceptor returns interceptor of recording and replaying
+func (c *Cassette) Interceptor() Interceptor {
+	return func(next Sender) Sender {
+		return func(req *http.Request) (*http.Response, error) {
+			body, err := cassetteBody(req)
+			if err != nil {
+				return nil, err
+			}
+
+			if c.Mode != CassetteRecord {
+				if e := c.find(req, body); e != nil {
+					return e.Response.response(req)
+				}
+				if c.Mode == CassetteReplay {
+					return nil, fmt.Errorf("xhttp: cassette interaction not found: %s %s", req.Method, req.URL)
+				}
+			}
+
+			rsp, err := next(req)
+			if err != nil {
+				return rsp, err
+			}
+
+			rspBody, err := ioutil.ReadAll(rsp.Body)
+			rsp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("xhttp: read response for cassette failed: %s", err.Error())
+			}
+			rsp.Body = ioutil.NopCloser(bytes.NewReader(rspBody))
+
+			e := &Interaction{
+				Request: CassetteRequest{
+					Method: req.Method,
+					URL:    req.URL.String(),
+					Header: c.redact(req.Header),
+				},
+				Response: CassetteResponse{
+					StatusCode: rsp.StatusCode,
+					Header:     c.redact(rsp.Header),
+				},
+			}
+			e.Request.Body, e.Request.Base64 = encodeCassetteBody(body)
+			e.Response.Body, e.Response.Base64 = encodeCassetteBody(rspBody)
+
+			return rsp, c.add(e)
+		}
+	}
+}
+
+// Save write cassette to file, it is written to temp file and renamed
+func (c *Cassette) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.save()
+}
+
+// add add interaction to cassette and save it
+func (c *Cassette) add(e *Interaction) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.played == nil {
+		c.played = map[*Interaction]bool{}
+	}
+
+	c.Interactions = append(c.Interactions, e)
+	c.played[e] = true
+
+	return c.save()
+}
+
+// save write cassette to file without lock
+func (c *Cassette) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("xhttp: encode cassette failed: %s", err.Error())
+	}
+
+	tpath := c.Path + ".tmp"
+	err = xfile.Write(tpath, data)
+	if err != nil {
+		return fmt.Errorf("xhttp: write cassette failed: %s", err.Error())
+	}
+
+	return os.Rename(tpath, c.Path)
+}
+
+// find returns the first not played interaction matched the request, or the last matched one
+func (c *Cassette) find(req *http.Request, body []byte) *Interaction {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.played == nil {
+		c.played = map[*Interaction]bool{}
+	}
+
+	var last *Interaction
+	for _, e := range c.Interactions {
+		if !c.match(e, req, body) {
+			continue
+		}
+		if !c.played[e] {
+			c.played[e] = true
+			return e
+		}
+		last = e
+	}
+
+	return last
+}
+
+// match returns if the interaction is matched the request
+func (c *Cassette) match(e *Interaction, req *http.Request, body []byte) bool {
+	if e.Request.Method != req.Method || e.Request.URL != req.URL.String() {
+		return false
+	}
+
+	if c.MatchBody {
+		b, err := decodeCassetteBody(e.Request.Body, e.Request.Base64)
+		if err != nil || !bytes.Equal(b, body) {
+			return false
+		}
+	}
+
+	for _, h := range c.MatchHeaders {
+		v := req.Header.Get(h)
+		if c.isRedacted(h) && v != "" {
+			v = redactedValue
+		}
+		if e.Request.Header.Get(h) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// redact returns copy of header with the secret headers redacted
+func (c *Cassette) redact(header http.Header) http.Header {
+	h := cloneHeader(header)
+	for k, v := range h {
+		if c.isRedacted(k) {
+			for i := range v {
+				v[i] = redactedValue
+			}
+		}
+	}
+
+	return h
+}
+
+// isRedacted returns if the header is redacted
+func (c *Cassette) isRedacted(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, v := range c.Redact {
+		if http.CanonicalHeaderKey(v) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// response returns http response of recorded response
+func (r CassetteResponse) response(req *http.Request) (*http.Response, error) {
+	body, err := decodeCassetteBody(r.Body, r.Base64)
+	if err != nil {
+		return nil, fmt.Errorf("xhttp: decode cassette body failed: %s", err.Error())
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        cloneHeader(r.Header),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// cassetteBody returns request body, the body is reset for sending
+func cassetteBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return []byte{}, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("xhttp: read request for cassette failed: %s", err.Error())
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// encodeCassetteBody returns body as string, it is base64 encoded if not valid utf8
+func encodeCassetteBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+// decodeCassetteBody returns body of string
+func decodeCassetteBody(s string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+
+	return []byte(s), nil
+}
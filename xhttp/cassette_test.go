@@ -0,0 +1,195 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xfile"
+)
+
+func TestCassetteBody(t *testing.T) {
+	s, ok := encodeCassetteBody([]byte("likexian"))
+	assert.False(t, ok)
+	assert.Equal(t, s, "likexian")
+
+	b, err := decodeCassetteBody(s, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, b, []byte("likexian"))
+
+	s, ok = encodeCassetteBody([]byte{0xff, 0xfe, 0x00})
+	assert.True(t, ok)
+	assert.Equal(t, s, "//4A")
+
+	b, err = decodeCassetteBody(s, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, b, []byte{0xff, 0xfe, 0x00})
+
+	_, err = decodeCassetteBody("!", true)
+	assert.NotNil(t, err)
+}
+
+func TestCassette(t *testing.T) {
+	defer os.RemoveAll("tmp-cassette")
+
+	var called int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&called, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Count", fmt.Sprint(n))
+		if r.URL.Path == "/binary" {
+			w.Write([]byte{0xff, 0xfe, 0x00})
+			return
+		}
+		fmt.Fprintf(w, "%s %s %s %d", r.Method, r.URL.Path, body, n)
+	}))
+
+	fpath := "tmp-cassette/test.json"
+	ctx := context.Background()
+
+	c, err := NewCassette(fpath, CassetteRecord)
+	assert.Nil(t, err)
+	c.MatchBody = true
+	c.MatchHeaders = []string{"Authorization"}
+
+	req := New().SetCassette(c)
+	for _, v := range []string{"a", "b", "a"} {
+		rsp, err := req.Post(ctx, ts.URL+"/post", FormParam{"v": v}, Header{"Authorization": "Bearer secret"})
+		assert.Nil(t, err)
+		_, err = rsp.String()
+		assert.Nil(t, err)
+	}
+
+	rsp, err := req.Get(ctx, ts.URL+"/binary")
+	assert.Nil(t, err)
+	rsp.Close()
+
+	assert.Equal(t, atomic.LoadInt32(&called), int32(4))
+	assert.Equal(t, len(c.Interactions), 4)
+
+	text, err := xfile.ReadText(fpath)
+	assert.Nil(t, err)
+	assert.NotContains(t, text, "secret")
+	assert.Contains(t, text, redactedValue)
+
+	ts.Close()
+
+	c, err = NewCassette(fpath, CassetteReplay)
+	assert.Nil(t, err)
+	c.MatchBody = true
+	c.MatchHeaders = []string{"Authorization"}
+	assert.Equal(t, len(c.Interactions), 4)
+
+	req = New().SetCassette(c)
+	for _, v := range []string{"POST /post v=a 1", "POST /post v=a 3", "POST /post v=a 3"} {
+		rsp, err := req.Post(ctx, ts.URL+"/post", FormParam{"v": "a"}, Header{"Authorization": "Bearer other"})
+		assert.Nil(t, err)
+		text, err := rsp.String()
+		assert.Nil(t, err)
+		assert.Equal(t, text, v)
+		assert.Equal(t, rsp.StatusCode, http.StatusOK)
+		assert.Equal(t, rsp.GetHeader("Set-Cookie"), redactedValue)
+	}
+
+	rsp, err = req.Post(ctx, ts.URL+"/post", FormParam{"v": "b"}, Header{"Authorization": "Bearer other"})
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "POST /post v=b 2")
+
+	rsp, err = req.Get(ctx, ts.URL+"/binary")
+	assert.Nil(t, err)
+	b, err := rsp.Bytes()
+	assert.Nil(t, err)
+	assert.Equal(t, b, []byte{0xff, 0xfe, 0x00})
+
+	_, err = req.Post(ctx, ts.URL+"/post", FormParam{"v": "c"}, Header{"Authorization": "Bearer other"})
+	assert.NotNil(t, err)
+
+	_, err = req.Post(ctx, ts.URL+"/post", FormParam{"v": "a"})
+	assert.NotNil(t, err)
+
+	_, err = NewCassette("tmp-cassette/404.json", CassetteReplay)
+	assert.NotNil(t, err)
+
+	err = xfile.WriteText("tmp-cassette/bad.json", "{")
+	assert.Nil(t, err)
+	_, err = NewCassette("tmp-cassette/bad.json", CassetteReplay)
+	assert.NotNil(t, err)
+}
+
+func TestCassetteAuto(t *testing.T) {
+	defer os.RemoveAll("tmp-cassette-auto")
+
+	var called int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %d", r.URL.Path, atomic.AddInt32(&called, 1))
+	}))
+	defer ts.Close()
+
+	fpath := "tmp-cassette-auto/test.json"
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		c, err := NewCassette(fpath, CassetteAuto)
+		assert.Nil(t, err)
+		req := New().SetCassette(c)
+		for _, v := range []string{"/a 1", "/b 2", "/a 1"} {
+			rsp, err := req.Get(ctx, ts.URL+v[:2])
+			assert.Nil(t, err)
+			text, err := rsp.String()
+			assert.Nil(t, err)
+			assert.Equal(t, text, v)
+		}
+		assert.Equal(t, atomic.LoadInt32(&called), int32(2))
+	}
+
+	c, err := NewCassette(fpath, CassetteAuto)
+	assert.Nil(t, err)
+	assert.Equal(t, len(c.Interactions), 2)
+	err = c.Save()
+	assert.Nil(t, err)
+	c = &Cassette{Path: "tmp-cassette-auto/literal.json", Mode: CassetteAuto}
+	req := New().SetCassette(c)
+	for _, v := range []string{"/c 3", "/c 3"} {
+		rsp, err := req.Get(ctx, ts.URL+v[:2])
+		assert.Nil(t, err)
+		text, err := rsp.String()
+		assert.Nil(t, err)
+		assert.Equal(t, text, v)
+	}
+
+	c = &Cassette{Mode: CassetteReplay, Interactions: c.Interactions}
+	rsp, err := New().SetCassette(c).Get(ctx, ts.URL+"/c")
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "/c 3")
+	assert.Equal(t, atomic.LoadInt32(&called), int32(3))
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/likexian/gokit/xip"
+)
+
+// ClientIPResolver is resolving real client ip behind trusted proxies,
+// TrustedProxies is list of trusted proxy ip or cidr, TrustPrivate is trusting all private ip,
+// Headers is list of headers for forwarding chain, the first present one is used
+type ClientIPResolver struct {
+	TrustedProxies []string
+	TrustPrivate   bool
+	Headers        []string
+}
+
+// NewClientIPResolver returns a new client ip resolver trusting the proxies,
+// forwarding chain is read from Forwarded, X-Forwarded-For or X-Real-Ip header
+func NewClientIPResolver(trustedProxies ...string) *ClientIPResolver {
+	return &ClientIPResolver{
+		TrustedProxies: trustedProxies,
+		TrustPrivate:   false,
+		Headers:        []string{"Forwarded", "X-Forwarded-For", "X-Real-Ip"},
+	}
+}
+
+// IsTrusted returns if ip is a trusted proxy
+func (c *ClientIPResolver) IsTrusted(ip string) bool {
+	if ip == "" {
+		return false
+	}
+
+	if c.TrustPrivate && xip.IsPrivate(ip) {
+		return true
+	}
+
+	for _, v := range c.TrustedProxies {
+		if strings.Contains(v, "/") {
+			if xip.IsContains(v, ip) {
+				return true
+			}
+		} else if parseNodeIP(v) == ip {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIPs returns ips of forwarding chain and remote address, from client to the nearest proxy,
+// the invalid ip in chain is kept as empty string, headers are used only if remote address is trusted
+func (c *ClientIPResolver) ClientIPs(r *http.Request) []string {
+	remote := parseNodeIP(r.RemoteAddr)
+	if !c.IsTrusted(remote) {
+		return []string{remote}
+	}
+
+	return append(c.forwardedChain(r), remote)
+}
+
+// ClientIP returns the real client ip, it walks the chain from the right and returns the first not trusted ip,
+// if an invalid ip is found, the nearest trusted ip is returned
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	ips := c.ClientIPs(r)
+
+	ip := ips[len(ips)-1]
+	for i := len(ips) - 1; i >= 0; i-- {
+		if ips[i] == "" {
+			break
+		}
+		ip = ips[i]
+		if !c.IsTrusted(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// forwardedChain returns ips of forwarding chain from the first present header
+func (c *ClientIPResolver) forwardedChain(r *http.Request) []string {
+	for _, h := range c.Headers {
+		values := r.Header[http.CanonicalHeaderKey(h)]
+		if len(values) == 0 {
+			continue
+		}
+		if strings.EqualFold(h, "Forwarded") {
+			return parseForwarded(values)
+		}
+		ips := []string{}
+		for _, v := range values {
+			for _, vv := range strings.Split(v, ",") {
+				if strings.TrimSpace(vv) != "" {
+					ips = append(ips, parseNodeIP(vv))
+				}
+			}
+		}
+		return ips
+	}
+
+	return []string{}
+}
+
+// parseForwarded returns ips of for parameter in RFC 7239 Forwarded header
+func parseForwarded(values []string) []string {
+	ips := []string{}
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			for _, p := range strings.Split(e, ";") {
+				kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					ips = append(ips, parseNodeIP(kv[1]))
+				}
+			}
+		}
+	}
+
+	return ips
+}
+
+// parseNodeIP returns ip of node in form of ip, ip:port, [ipv6] or [ipv6]:port, returns empty if invalid
+func parseNodeIP(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), "\"")
+	if strings.HasPrefix(s, "[") {
+		n := strings.Index(s, "]")
+		if n == -1 {
+			return ""
+		}
+		s = s[1:n]
+	} else if ip := net.ParseIP(s); ip == nil {
+		host, _, err := net.SplitHostPort(s)
+		if err != nil {
+			return ""
+		}
+		s = host
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestParseNodeIP(t *testing.T) {
+	tests := map[string]string{
+		"1.1.1.1":                      "1.1.1.1",
+		" 1.1.1.1:1234 ":               "1.1.1.1",
+		"2001:db8::1":                  "2001:db8::1",
+		"[2001:db8::1]":                "2001:db8::1",
+		"[2001:db8::1]:1234":           "2001:db8::1",
+		"\"[2001:DB8:cafe::17]:4711\"": "2001:db8:cafe::17",
+		"unknown":                      "",
+		"_hidden":                      "",
+		"[2001:db8::1":                 "",
+		"":                             "",
+	}
+
+	for k, v := range tests {
+		assert.Equal(t, parseNodeIP(k), v, k)
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	ips := parseForwarded([]string{
+		"for=192.0.2.60;proto=http;by=203.0.113.43, For=\"[2001:db8:cafe::17]:4711\"",
+		"for=unknown, by=10.0.0.1",
+	})
+	assert.Equal(t, ips, []string{"192.0.2.60", "2001:db8:cafe::17", ""})
+}
+
+func TestClientIPResolver(t *testing.T) {
+	c := NewClientIPResolver("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+
+	assert.True(t, c.IsTrusted("10.1.1.1"))
+	assert.True(t, c.IsTrusted("192.168.1.1"))
+	assert.True(t, c.IsTrusted("2001:db8::1"))
+	assert.False(t, c.IsTrusted("192.168.1.2"))
+	assert.False(t, c.IsTrusted("1.1.1.1"))
+	assert.False(t, c.IsTrusted(""))
+
+	r := &http.Request{
+		RemoteAddr: "1.1.1.1:1234",
+		Header: http.Header{
+			"X-Forwarded-For": []string{"2.2.2.2"},
+		},
+	}
+
+	assert.Equal(t, c.ClientIPs(r), []string{"1.1.1.1"})
+	assert.Equal(t, c.ClientIP(r), "1.1.1.1")
+
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "3.3.3.3, 2.2.2.2, 10.0.0.2")
+	assert.Equal(t, c.ClientIPs(r), []string{"3.3.3.3", "2.2.2.2", "10.0.0.2", "10.0.0.1"})
+	assert.Equal(t, c.ClientIP(r), "2.2.2.2")
+
+	r.Header.Set("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+	assert.Equal(t, c.ClientIP(r), "10.0.0.3")
+
+	r.Header.Set("X-Forwarded-For", "2.2.2.2, unknown, 10.0.0.2")
+	assert.Equal(t, c.ClientIP(r), "10.0.0.2")
+
+	r.Header.Set("Forwarded", "for=4.4.4.4, for=\"[2001:db8::2]:80\"")
+	assert.Equal(t, c.ClientIPs(r), []string{"4.4.4.4", "2001:db8::2", "10.0.0.1"})
+	assert.Equal(t, c.ClientIP(r), "4.4.4.4")
+
+	r.Header = http.Header{"X-Real-Ip": []string{"5.5.5.5"}}
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	assert.Equal(t, c.ClientIP(r), "5.5.5.5")
+
+	r.Header = http.Header{}
+	assert.Equal(t, c.ClientIPs(r), []string{"2001:db8::1"})
+	assert.Equal(t, c.ClientIP(r), "2001:db8::1")
+
+	c = NewClientIPResolver()
+	c.TrustPrivate = true
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 192.168.0.1")
+	assert.Equal(t, c.ClientIP(r), "6.6.6.6")
+}
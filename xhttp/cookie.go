@@ -0,0 +1,479 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likexian/gokit/xfile"
+	"github.com/likexian/gokit/xjson"
+)
+
+// CookieJar is http cookie jar that can be saved to file, cookies follow the RFC 6265,
+// the jar is saved on every change if Path is not empty
+type CookieJar struct {
+	Path             string
+	SaveSession      bool
+	PublicSuffixList cookiejar.PublicSuffixList
+	mutex            sync.Mutex
+	entries          map[string]*cookieEntry
+}
+
+// cookieEntry is cookie stored in jar
+type cookieEntry struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain"`
+	Path     string `json:"path"`
+	Expires  int64  `json:"expires"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"http_only"`
+	HostOnly bool   `json:"host_only"`
+	Created  int64  `json:"created"`
+}
+
+// cookieFile is content of cookie file
+type cookieFile struct {
+	Version int            `json:"version"`
+	Cookies []*cookieEntry `json:"cookies"`
+}
+
+// NewCookieJar returns a new cookie jar, the cookies are loaded from file if fpath exists,
+// session cookies are saved by default, so the login is kept between runs, psl is the public suffix
+// list for checking cookie domain, for example publicsuffix.List of golang.org/x/net/publicsuffix,
+// if psl is nil the domain must be the host that set the cookie, else it is treated as host only
+func NewCookieJar(fpath string, psl cookiejar.PublicSuffixList) (*CookieJar, error) {
+	j := &CookieJar{
+		Path:             fpath,
+		SaveSession:      true,
+		PublicSuffixList: psl,
+		entries:          map[string]*cookieEntry{},
+	}
+
+	if fpath == "" || !xfile.Exists(fpath) {
+		return j, nil
+	}
+
+	data, err := xjson.Load(fpath)
+	if err != nil {
+		return nil, fmt.Errorf("xhttp: load cookie failed: %s", err.Error())
+	}
+
+	cookies := data.Get("cookies")
+	for i := 0; i < cookies.Len(); i++ {
+		v := cookies.Index(i)
+		e := &cookieEntry{
+			Name:     v.Get("name").MustString(""),
+			Value:    v.Get("value").MustString(""),
+			Domain:   v.Get("domain").MustString(""),
+			Path:     v.Get("path").MustString("/"),
+			Expires:  v.Get("expires").MustInt64(0),
+			Secure:   v.Get("secure").MustBool(false),
+			HttpOnly: v.Get("http_only").MustBool(false),
+			HostOnly: v.Get("host_only").MustBool(false),
+			Created:  v.Get("created").MustInt64(0),
+		}
+		if e.Name != "" && e.Domain != "" {
+			j.entries[e.key()] = e
+		}
+	}
+
+	return j, nil
+}
+
+// SetCookieJar set cookie jar of request, nil jar disables cookie
+func (r *Request) SetCookieJar(jar http.CookieJar) *Request {
+	r.Client.Jar = jar
+	return r
+}
+
+// ListCookies returns all not expired cookies of request if jar is created by NewCookieJar,
+// the domain of cookie not host only is prefixed with dot
+func (r *Request) ListCookies() []*http.Cookie {
+	if j, ok := r.Client.Jar.(*CookieJar); ok {
+		return j.List()
+	}
+
+	return []*http.Cookie{}
+}
+
+// ImportCookies import cookies to request, a memory jar by NewCookieJar is set if cookie is not enabled,
+// the domain prefixed with dot is matching subdomains if the jar has public suffix list, else matching the host only
+func (r *Request) ImportCookies(cookies ...*http.Cookie) *Request {
+	if r.Client.Jar == nil {
+		r.Client.Jar, _ = NewCookieJar("", nil)
+	}
+
+	importCookies(r.Client.Jar, cookies...)
+
+	return r
+}
+
+// ExportCookies export cookies of request to file
+func (r *Request) ExportCookies(fpath string) error {
+	j, ok := r.Client.Jar.(*CookieJar)
+	if !ok {
+		return fmt.Errorf("xhttp: cookie jar is not exportable")
+	}
+
+	return j.Export(fpath)
+}
+
+// SetCookies implements http.CookieJar, it stores cookies received from url
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+
+	host := cookieHost(u.Host)
+	if host == "" {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	changed := false
+	for i, c := range cookies {
+		domain, hostOnly, ok := j.domain(host, c.Domain)
+		if !ok || c.Name == "" {
+			continue
+		}
+
+		e := &cookieEntry{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			HostOnly: hostOnly,
+			Created:  now.UnixNano() + int64(i),
+		}
+
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = defaultCookiePath(u.Path)
+		}
+
+		if c.MaxAge < 0 || (c.MaxAge == 0 && !c.Expires.IsZero() && !c.Expires.After(now)) {
+			if _, ok := j.entries[e.key()]; ok {
+				delete(j.entries, e.key())
+				changed = true
+			}
+			continue
+		}
+
+		if c.MaxAge > 0 {
+			e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second).Unix()
+		} else if !c.Expires.IsZero() {
+			e.Expires = c.Expires.Unix()
+		}
+
+		if old, ok := j.entries[e.key()]; ok {
+			e.Created = old.Created
+		}
+
+		j.entries[e.key()] = e
+		changed = true
+	}
+
+	if changed && j.Path != "" {
+		_ = j.save(j.Path)
+	}
+}
+
+// Cookies implements http.CookieJar, it returns cookies to send to url, expired cookies are removed
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	host := cookieHost(u.Host)
+	if host == "" {
+		return nil
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.clean()
+
+	es := []*cookieEntry{}
+	for _, e := range j.entries {
+		if e.Secure && u.Scheme != "https" {
+			continue
+		}
+		if !e.domainMatch(host) || !cookiePathMatch(e.Path, path) {
+			continue
+		}
+		es = append(es, e)
+	}
+
+	sort.Slice(es, func(i, k int) bool {
+		if len(es[i].Path) != len(es[k].Path) {
+			return len(es[i].Path) > len(es[k].Path)
+		}
+		if es[i].Created != es[k].Created {
+			return es[i].Created < es[k].Created
+		}
+		return es[i].key() < es[k].key()
+	})
+
+	cookies := []*http.Cookie{}
+	for _, e := range es {
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+
+	return cookies
+}
+
+// List returns all not expired cookies of jar, the domain of cookie not host only is prefixed with dot
+func (j *CookieJar) List() []*http.Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.clean()
+
+	cookies := []*http.Cookie{}
+	for _, e := range j.sorted() {
+		cookies = append(cookies, e.cookie())
+	}
+
+	return cookies
+}
+
+// Import import cookies to jar, the domain prefixed with dot is matching subdomains,
+// else matching the host only, cookies without domain are ignored
+func (j *CookieJar) Import(cookies ...*http.Cookie) {
+	importCookies(j, cookies...)
+}
+
+// Export write all not expired cookies to file, it is written to temp file and renamed
+func (j *CookieJar) Export(fpath string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.clean()
+
+	return j.save(fpath)
+}
+
+// Save write cookies to file of jar
+func (j *CookieJar) Save() error {
+	if j.Path == "" {
+		return fmt.Errorf("xhttp: cookie file path is empty")
+	}
+
+	return j.Export(j.Path)
+}
+
+// Clean remove expired cookies from jar
+func (j *CookieJar) Clean() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.clean()
+}
+
+// clean remove expired cookies without lock
+func (j *CookieJar) clean() {
+	now := time.Now().Unix()
+	for k, e := range j.entries {
+		if e.Expires > 0 && e.Expires <= now {
+			delete(j.entries, k)
+		}
+	}
+}
+
+// sorted returns cookies entries sorted by domain, path and name without lock
+func (j *CookieJar) sorted() []*cookieEntry {
+	es := make([]*cookieEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		es = append(es, e)
+	}
+
+	sort.Slice(es, func(i, k int) bool {
+		return es[i].key() < es[k].key()
+	})
+
+	return es
+}
+
+// save write cookies to file without lock, it is written to temp file and renamed
+func (j *CookieJar) save(fpath string) error {
+	data := cookieFile{
+		Version: 1,
+		Cookies: []*cookieEntry{},
+	}
+
+	for _, e := range j.sorted() {
+		if e.Expires > 0 || j.SaveSession {
+			data.Cookies = append(data.Cookies, e)
+		}
+	}
+
+	text, err := xjson.PrettyDumps(data)
+	if err != nil {
+		return fmt.Errorf("xhttp: encode cookie failed: %s", err.Error())
+	}
+
+	tpath := fpath + ".tmp"
+	err = xfile.WriteText(tpath, text)
+	if err != nil {
+		return fmt.Errorf("xhttp: write cookie failed: %s", err.Error())
+	}
+
+	return os.Rename(tpath, fpath)
+}
+
+// domain returns domain of cookie and if it is host only, ok is false if the domain is not allowed,
+// the cookie is host only if there is no public suffix list for checking the domain
+func (j *CookieJar) domain(host, domain string) (string, bool, bool) {
+	domain = strings.ToLower(strings.TrimLeft(domain, "."))
+	if domain == "" {
+		return host, true, true
+	}
+
+	if domain == host {
+		if net.ParseIP(host) != nil {
+			return host, true, true
+		}
+	} else if net.ParseIP(host) != nil || !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+
+	if j.PublicSuffixList == nil {
+		return host, true, true
+	}
+
+	if ps := j.PublicSuffixList.PublicSuffix(domain); ps == domain {
+		if host == domain {
+			return host, true, true
+		}
+		return "", false, false
+	}
+
+	return domain, false, true
+}
+
+// importCookies set cookies to jar by the url of cookie domain
+func importCookies(jar http.CookieJar, cookies ...*http.Cookie) {
+	for _, c := range cookies {
+		domain := strings.ToLower(strings.TrimSpace(c.Domain))
+		if strings.TrimLeft(domain, ".") == "" {
+			continue
+		}
+
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+
+		cc := *c
+		if !strings.HasPrefix(domain, ".") {
+			cc.Domain = ""
+		}
+
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimLeft(domain, "."), Path: c.Path}, []*http.Cookie{&cc})
+	}
+}
+
+// key returns unique key of cookie entry
+func (e *cookieEntry) key() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+// domainMatch returns if the cookie is matched the host
+func (e *cookieEntry) domainMatch(host string) bool {
+	if e.Domain == host {
+		return true
+	}
+
+	return !e.HostOnly && strings.HasSuffix(host, "."+e.Domain)
+}
+
+// cookie returns http cookie of cookie entry
+func (e *cookieEntry) cookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     e.Name,
+		Value:    e.Value,
+		Domain:   e.Domain,
+		Path:     e.Path,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+	}
+
+	if !e.HostOnly {
+		c.Domain = "." + e.Domain
+	}
+
+	if e.Expires > 0 {
+		c.Expires = time.Unix(e.Expires, 0)
+	}
+
+	return c
+}
+
+// cookieHost returns lower case host without port
+func cookieHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+}
+
+// defaultCookiePath returns default cookie path of url path
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+
+	return path[:i]
+}
+
+// cookiePathMatch returns if the request path is matched the cookie path
+func cookiePathMatch(cookiePath, path string) bool {
+	if cookiePath == path {
+		return true
+	}
+
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+
+	return strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xfile"
+)
+
+func cookieNames(cookies []*http.Cookie) []string {
+	names := []string{}
+	for _, v := range cookies {
+		names = append(names, v.Name+"="+v.Value)
+	}
+
+	return names
+}
+
+// testSuffixList is public suffix list for testing, co.uk and github.io are the only multiple label suffixes
+type testSuffixList struct{}
+
+func (testSuffixList) PublicSuffix(domain string) string {
+	for _, v := range []string{"co.uk", "github.io"} {
+		if domain == v || strings.HasSuffix(domain, "."+v) {
+			return v
+		}
+	}
+
+	return domain[strings.LastIndex(domain, ".")+1:]
+}
+
+func (testSuffixList) String() string {
+	return "test"
+}
+
+func TestCookieHelper(t *testing.T) {
+	assert.Equal(t, cookieHost("WWW.Example.com:8080"), "www.example.com")
+	assert.Equal(t, cookieHost("[::1]:8080"), "::1")
+	assert.Equal(t, cookieHost("example.com."), "example.com")
+
+	assert.Equal(t, defaultCookiePath(""), "/")
+	assert.Equal(t, defaultCookiePath("/"), "/")
+	assert.Equal(t, defaultCookiePath("/a"), "/")
+	assert.Equal(t, defaultCookiePath("/a/b"), "/a")
+
+	assert.True(t, cookiePathMatch("/", "/a"))
+	assert.True(t, cookiePathMatch("/a", "/a"))
+	assert.True(t, cookiePathMatch("/a", "/a/b"))
+	assert.False(t, cookiePathMatch("/a", "/ab"))
+	assert.False(t, cookiePathMatch("/a/b", "/a"))
+
+}
+
+func TestCookieJar(t *testing.T) {
+	j, err := NewCookieJar("", testSuffixList{})
+	assert.Nil(t, err)
+
+	u, _ := url.Parse("https://www.example.co.uk/a/b")
+	j.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.co.uk", Path: "/"},
+		{Name: "suffix", Value: "3", Domain: "co.uk"},
+		{Name: "other", Value: "4", Domain: "other.co.uk"},
+		{Name: "secure", Value: "5", Path: "/", Secure: true},
+		{Name: "expired", Value: "6", Expires: time.Now().Add(-time.Hour)},
+		{Name: "max", Value: "7", Path: "/", MaxAge: 3600},
+	})
+
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"host=1", "domain=2", "secure=5", "max=7"})
+
+	u, _ = url.Parse("http://api.example.co.uk/a")
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"domain=2"})
+
+	u, _ = url.Parse("http://www.example.co.uk/")
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"domain=2", "max=7"})
+
+	u, _ = url.Parse("ftp://www.example.co.uk/")
+	j.SetCookies(u, []*http.Cookie{{Name: "ftp", Value: "8"}})
+	assert.Equal(t, len(j.Cookies(u)), 0)
+
+	u, _ = url.Parse("http://www.example.co.uk/")
+	j.SetCookies(u, []*http.Cookie{{Name: "max", Path: "/", MaxAge: -1}})
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"domain=2"})
+
+	u, _ = url.Parse("http://127.0.0.1/")
+	j.SetCookies(u, []*http.Cookie{{Name: "ip", Value: "1", Domain: "127.0.0.1"}, {Name: "bad", Value: "2", Domain: "0.0.1"}})
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"ip=1"})
+
+	u, _ = url.Parse("http://github.io/")
+	j.SetCookies(u, []*http.Cookie{{Name: "suffix", Value: "1", Domain: "github.io"}})
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"suffix=1"})
+	u, _ = url.Parse("http://likexian.github.io/")
+	assert.Equal(t, len(j.Cookies(u)), 0)
+
+	j, err = NewCookieJar("", nil)
+	assert.Nil(t, err)
+
+	u, _ = url.Parse("http://evil.com.mx/")
+	j.SetCookies(u, []*http.Cookie{{Name: "suffix", Value: "1", Domain: "com.mx"}, {Name: "host", Value: "2", Domain: "evil.com.mx"}})
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"suffix=1", "host=2"})
+	u, _ = url.Parse("http://www.evil.com.mx/")
+	assert.Equal(t, len(j.Cookies(u)), 0)
+	u, _ = url.Parse("http://other.com.mx/")
+	assert.Equal(t, len(j.Cookies(u)), 0)
+
+	u, _ = url.Parse("http://www.likexian.com/")
+	j.SetCookies(u, []*http.Cookie{{Name: "domain", Value: "1", Domain: ".likexian.com"}})
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"domain=1"})
+	u, _ = url.Parse("http://api.likexian.com/")
+	assert.Equal(t, len(j.Cookies(u)), 0)
+
+	j.entries["expired"] = &cookieEntry{Name: "expired", Domain: "example.com", Expires: 1}
+	j.Clean()
+	_, ok := j.entries["expired"]
+	assert.False(t, ok)
+}
+
+func TestCookieJarFile(t *testing.T) {
+	defer os.RemoveAll("tmp-cookie")
+
+	fpath := "tmp-cookie/cookie.json"
+	j, err := NewCookieJar(fpath, testSuffixList{})
+	assert.Nil(t, err)
+
+	err = j.Save()
+	assert.Nil(t, err)
+
+	u, _ := url.Parse("https://www.likexian.com/")
+	j.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "1", Path: "/"},
+		{Name: "token", Value: "2", Domain: "likexian.com", Path: "/", MaxAge: 3600, HttpOnly: true},
+	})
+
+	text, err := xfile.ReadText(fpath)
+	assert.Nil(t, err)
+	assert.Contains(t, text, "session")
+	assert.Contains(t, text, "token")
+
+	j, err = NewCookieJar(fpath, testSuffixList{})
+	assert.Nil(t, err)
+	assert.Equal(t, cookieNames(j.Cookies(u)), []string{"session=1", "token=2"})
+
+	cookies := j.List()
+	assert.Equal(t, len(cookies), 2)
+	assert.Equal(t, cookies[0].Domain, ".likexian.com")
+	assert.True(t, cookies[0].HttpOnly)
+	assert.False(t, cookies[0].Expires.IsZero())
+	assert.Equal(t, cookies[1].Domain, "www.likexian.com")
+
+	j.SaveSession = false
+	err = j.Export("tmp-cookie/export.json")
+	assert.Nil(t, err)
+
+	e, err := NewCookieJar("tmp-cookie/export.json", testSuffixList{})
+	assert.Nil(t, err)
+	assert.Equal(t, cookieNames(e.Cookies(u)), []string{"token=2"})
+
+	j, err = NewCookieJar("", testSuffixList{})
+	assert.Nil(t, err)
+	err = j.Save()
+	assert.NotNil(t, err)
+
+	err = xfile.WriteText("tmp-cookie/bad.json", "{")
+	assert.Nil(t, err)
+	_, err = NewCookieJar("tmp-cookie/bad.json", nil)
+	assert.NotNil(t, err)
+}
+
+func TestRequestCookies(t *testing.T) {
+	defer os.RemoveAll("tmp-cookie-request")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/set" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "likexian", Path: "/"})
+			return
+		}
+		c, err := r.Cookie("session")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(c.Value))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	fpath := "tmp-cookie-request/cookie.json"
+
+	req := New()
+	assert.Equal(t, len(req.ListCookies()), 0)
+	assert.NotNil(t, req.ExportCookies(fpath))
+
+	j, err := NewCookieJar(fpath, testSuffixList{})
+	assert.Nil(t, err)
+	req.SetCookieJar(j)
+
+	rsp, err := req.Get(ctx, ts.URL+"/set")
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, len(req.ListCookies()), 1)
+
+	j, err = NewCookieJar(fpath, testSuffixList{})
+	assert.Nil(t, err)
+	req = New().SetCookieJar(j)
+	rsp, err = req.Get(ctx, ts.URL+"/get")
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+
+	err = req.ExportCookies("tmp-cookie-request/export.json")
+	assert.Nil(t, err)
+
+	u, _ := url.Parse(ts.URL)
+	req = New().ImportCookies(&http.Cookie{Name: "session", Value: "imported", Domain: u.Hostname(), Path: "/"},
+		&http.Cookie{Name: "nodomain", Value: "1"})
+	assert.Equal(t, len(req.ListCookies()), 1)
+	rsp, err = req.Get(ctx, ts.URL+"/get")
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "imported")
+
+	req = New().EnableCookie(true)
+	_, ok := req.Client.Jar.(*cookiejar.Jar)
+	assert.True(t, ok)
+	req.ImportCookies(&http.Cookie{Name: "session", Value: "stdlib", Domain: u.Hostname(), Path: "/"})
+	assert.Equal(t, len(req.ListCookies()), 0)
+	rsp, err = req.Get(ctx, ts.URL+"/get")
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "stdlib")
+
+	req.SetCookieJar(nil)
+	rsp, err = req.Get(ctx, ts.URL+"/get")
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusUnauthorized)
+}
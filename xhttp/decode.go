@@ -0,0 +1,183 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/likexian/gokit/xtime"
+)
+
+// Decoding default setting
+const (
+	defaultDecodeSize = 10 << 20
+	errorBodySize     = 1024
+)
+
+// Decoding storing response decoding setting
+type Decoding struct {
+	MaxSize   int64
+	HTTPError bool
+}
+
+// HTTPError is error returned for non-2xx response when HTTPError mode is enabled,
+// Body is the beginning of response body
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// Error returns error message
+func (e *HTTPError) Error() string {
+	s := fmt.Sprintf("xhttp: %s %s returns %s", e.Method, e.URL, e.Status)
+	if len(e.Body) > 0 {
+		s += ": " + strings.TrimSpace(string(e.Body))
+	}
+
+	return s
+}
+
+// IsHTTPError returns if error is non-2xx response error
+func IsHTTPError(err error) bool {
+	_, ok := err.(*HTTPError)
+	return ok
+}
+
+// SetDecodeLimit set max body size for DecodeJSON and DecodeXML, 0 or -1 is unlimited, default is 10 MB
+func (r *Request) SetDecodeLimit(size int64) *Request {
+	r.Decoding.MaxSize = size
+	return r
+}
+
+// EnableHTTPError set non-2xx response returns *HTTPError, the response body is read and closed
+func (r *Request) EnableHTTPError(enable bool) *Request {
+	r.Decoding.HTTPError = enable
+	return r
+}
+
+// DecodeJSON decode json response body to v, Content-Type must be json if it is set
+func (r *Response) DecodeJSON(v interface{}) error {
+	b, err := r.decodeBody("json")
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("xhttp: decode json failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// DecodeXML decode xml response body to v, Content-Type must be xml if it is set
+func (r *Response) DecodeXML(v interface{}) error {
+	b, err := r.decodeBody("xml")
+	if err != nil {
+		return err
+	}
+
+	err = xml.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("xhttp: decode xml failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// decodeBody check the Content-Type and returns response body limited by size
+func (r *Response) decodeBody(format string) ([]byte, error) {
+	defer r.Response.Body.Close()
+
+	ct := r.Response.Header.Get("Content-Type")
+	if ct != "" && !isMediaType(ct, format) {
+		return nil, fmt.Errorf("xhttp: content type %s is not %s", ct, format)
+	}
+
+	if r.maxSize <= 0 {
+		return r.Bytes()
+	}
+
+	startAt := xtime.Ms()
+	defer func() {
+		r.Tracing.RecvTime = xtime.Ms() - startAt
+	}()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Response.Body, r.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(b)) > r.maxSize {
+		return nil, fmt.Errorf("xhttp: response body is larger than %d bytes", r.maxSize)
+	}
+
+	return b, nil
+}
+
+// isMediaType returns if the Content-Type is format, such as application/json or application/problem+json
+func isMediaType(ct, format string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+
+	ts := strings.SplitN(mt, "/", 2)
+	if len(ts) != 2 {
+		return false
+	}
+
+	return ts[1] == format || strings.HasSuffix(ts[1], "+"+format)
+}
+
+// newHTTPError returns *HTTPError if status code of response is not 2xx, the response body is closed
+func newHTTPError(s *Response) error {
+	if s.StatusCode >= 200 && s.StatusCode < 300 {
+		return nil
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(s.Response.Body, errorBodySize))
+	discardBody(s.Response)
+
+	status := s.Response.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", s.StatusCode, http.StatusText(s.StatusCode))
+	}
+
+	return &HTTPError{
+		Method:     s.Method,
+		URL:        s.URL.String(),
+		StatusCode: s.StatusCode,
+		Status:     status,
+		Header:     s.Response.Header,
+		Body:       b,
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+type decodeUser struct {
+	Name string `json:"name" xml:"name"`
+	Age  int    `json:"age" xml:"age"`
+}
+
+func decodeServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprint(w, `{"name": "likexian", "age": 18}`)
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"name": "problem", "age": 0}`)
+		case "/notype":
+			w.Header()["Content-Type"] = nil
+			fmt.Fprint(w, `{"name": "likexian", "age": 18}`)
+		case "/badjson":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"name": `)
+		case "/xml":
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, `<user><name>likexian</name><age>18</age></user>`)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html></html>`)
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"name": "%s", "age": 18}`, strings.Repeat("x", 1024))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, strings.Repeat("x", 2048))
+		}
+	}))
+}
+
+func TestIsMediaType(t *testing.T) {
+	assert.True(t, isMediaType("application/json", "json"))
+	assert.True(t, isMediaType("application/json; charset=utf-8", "json"))
+	assert.True(t, isMediaType("application/problem+json", "json"))
+	assert.True(t, isMediaType("text/xml", "xml"))
+	assert.True(t, isMediaType("application/atom+xml", "xml"))
+	assert.False(t, isMediaType("text/html", "json"))
+	assert.False(t, isMediaType("application/jsonp", "json"))
+	assert.False(t, isMediaType("json", "json"))
+	assert.False(t, isMediaType(";", "json"))
+}
+
+func TestDecodeJSON(t *testing.T) {
+	ts := decodeServer()
+	defer ts.Close()
+
+	req := New()
+	ctx := context.Background()
+
+	for _, v := range []string{"/json", "/notype"} {
+		rsp, err := req.Get(ctx, ts.URL+v)
+		assert.Nil(t, err)
+		user := decodeUser{}
+		err = rsp.DecodeJSON(&user)
+		assert.Nil(t, err)
+		assert.Equal(t, user, decodeUser{"likexian", 18})
+	}
+
+	rsp, err := req.Get(ctx, ts.URL+"/problem")
+	assert.Nil(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusBadRequest)
+	user := decodeUser{}
+	err = rsp.DecodeJSON(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, user.Name, "problem")
+
+	rsp, err = req.Get(ctx, ts.URL+"/html")
+	assert.Nil(t, err)
+	err = rsp.DecodeJSON(&user)
+	assert.Equal(t, err.Error(), "xhttp: content type text/html is not json")
+
+	rsp, err = req.Get(ctx, ts.URL+"/badjson")
+	assert.Nil(t, err)
+	err = rsp.DecodeJSON(&user)
+	assert.NotNil(t, err)
+
+	req.SetDecodeLimit(1024)
+	rsp, err = req.Get(ctx, ts.URL+"/large")
+	assert.Nil(t, err)
+	err = rsp.DecodeJSON(&user)
+	assert.Equal(t, err.Error(), "xhttp: response body is larger than 1024 bytes")
+
+	req.SetDecodeLimit(0)
+	rsp, err = req.Get(ctx, ts.URL+"/large")
+	assert.Nil(t, err)
+	err = rsp.DecodeJSON(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, len(user.Name), 1024)
+}
+
+func TestDecodeXML(t *testing.T) {
+	ts := decodeServer()
+	defer ts.Close()
+
+	ctx := context.Background()
+	rsp, err := Get(ctx, ts.URL+"/xml")
+	assert.Nil(t, err)
+	user := decodeUser{}
+	err = rsp.DecodeXML(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, user, decodeUser{"likexian", 18})
+
+	rsp, err = Get(ctx, ts.URL+"/json")
+	assert.Nil(t, err)
+	err = rsp.DecodeXML(&user)
+	assert.Equal(t, err.Error(), "xhttp: content type application/json; charset=utf-8 is not xml")
+
+	rsp, err = Get(ctx, ts.URL+"/notype")
+	assert.Nil(t, err)
+	err = rsp.DecodeXML(&user)
+	assert.NotNil(t, err)
+}
+
+func TestHTTPError(t *testing.T) {
+	ts := decodeServer()
+	defer ts.Close()
+
+	ctx := context.Background()
+	rsp, err := New().Get(ctx, ts.URL+"/404")
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusNotFound)
+
+	req := New().EnableHTTPError(true)
+	assert.True(t, req.Clone().Decoding.HTTPError)
+
+	rsp, err = req.Get(ctx, ts.URL+"/json")
+	assert.Nil(t, err)
+	rsp.Close()
+
+	rsp, err = req.Get(ctx, ts.URL+"/404")
+	assert.NotNil(t, err)
+	assert.True(t, IsHTTPError(err))
+	assert.False(t, IsHTTPError(fmt.Errorf("xhttp: error")))
+	assert.Equal(t, rsp.StatusCode, http.StatusNotFound)
+
+	e := err.(*HTTPError)
+	assert.Equal(t, e.Method, "GET")
+	assert.Equal(t, e.URL, ts.URL+"/404")
+	assert.Equal(t, e.StatusCode, http.StatusNotFound)
+	assert.Equal(t, e.Status, "404 Not Found")
+	assert.Equal(t, e.Header.Get("Content-Type"), "text/plain")
+	assert.Equal(t, len(e.Body), errorBodySize)
+	assert.Equal(t, e.Error(), fmt.Sprintf("xhttp: GET %s/404 returns 404 Not Found: %s", ts.URL, e.Body))
+
+	req.OnBeforeSend(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	_, err = req.Get(ctx, ts.URL+"/json")
+	assert.Equal(t, err.Error(), fmt.Sprintf("xhttp: GET %s/json returns 503 Service Unavailable", ts.URL))
+}
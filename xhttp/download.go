@@ -0,0 +1,233 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/likexian/gokit/xfile"
+	"github.com/likexian/gokit/xhash"
+)
+
+// downloadSuffix is suffix of the temp file when downloading
+const downloadSuffix = ".download"
+
+// Checksum is expected digest of download file, method is md5, sha1 or sha256, value is hex string
+type Checksum struct {
+	Method string
+	Value  string
+}
+
+// Download do http GET request and save response to file,
+// if the partial temp file exists, the download is resumed by Range request
+// args are same as Do, Checksum and ProgressFunc arg are pass to Response.File
+func (r *Request) Download(ctx context.Context, surl, fpath string, args ...interface{}) (size int64, err error) {
+	u, err := url.Parse(strings.TrimSpace(surl))
+	if err != nil {
+		return 0, fmt.Errorf("xhttp: parse url failed: %s", err.Error())
+	}
+
+	fpath, err = downloadPath(fpath, u)
+	if err != nil {
+		return
+	}
+
+	if xfile.Exists(fpath) {
+		return 0, fmt.Errorf("file %s is exists", fpath)
+	}
+
+	fileArgs := []interface{}{fpath}
+	doArgs := []interface{}{}
+	for _, v := range args {
+		switch v.(type) {
+		case Checksum, ProgressFunc:
+			fileArgs = append(fileArgs, v)
+		default:
+			doArgs = append(doArgs, v)
+		}
+	}
+
+	if n, err := xfile.Size(fpath + downloadSuffix); err == nil && n > 0 {
+		doArgs = append(doArgs, Header{"Range": fmt.Sprintf("bytes=%d-", n)})
+	}
+
+	rsp, err := r.Do(ctx, "GET", surl, doArgs...)
+	if e, ok := err.(*HTTPError); ok && e.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		err = nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	return rsp.File(fileArgs...)
+}
+
+// downloadPath returns the file path to save, default is base name of url
+func downloadPath(fpath string, u *url.URL) (string, error) {
+	fpath = strings.TrimSpace(fpath)
+	if fpath == "" {
+		_, fpath = filepath.Split(u.String())
+		if fpath == "" {
+			fpath = "index.html"
+		}
+		return fpath, nil
+	}
+
+	dir, name := filepath.Split(fpath)
+	if name == "" {
+		fpath = dir + "index.html"
+	}
+
+	if dir != "" && !xfile.Exists(dir) {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fpath, nil
+}
+
+// downloadRange returns the offset of temp file to write and the total size, total is -1 if unknown
+func downloadRange(rsp *http.Response, tpath string) (offset, total int64, err error) {
+	size, err := xfile.Size(tpath)
+	if err != nil {
+		size = 0
+	}
+
+	switch rsp.StatusCode {
+	case http.StatusOK:
+		if rsp.ContentLength < 0 {
+			return 0, -1, nil
+		}
+		return 0, rsp.ContentLength, nil
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(rsp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, 0, err
+		}
+		if start != size {
+			return 0, 0, fmt.Errorf("xhttp: content range start %d not matched file size %d", start, size)
+		}
+		return start, total, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, total, err := parseContentRange(rsp.Header.Get("Content-Range"))
+		if err == nil && size > 0 && total == size {
+			return size, total, nil
+		}
+		os.Remove(tpath)
+	}
+
+	return 0, 0, fmt.Errorf("bad status code: %d", rsp.StatusCode)
+}
+
+// writeDownload write body to temp file from offset
+func writeDownload(tpath string, offset, total int64, body io.Reader, progress ProgressFunc) error {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	fd, err := os.OpenFile(tpath, flag, 0644)
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		body = newProgressReader(body, offset, total, progress)
+	}
+
+	_, err = io.Copy(fd, body)
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// parseContentRange returns the start and total of Content-Range header,
+// start is -1 if range is unsatisfied, total is -1 if unknown
+func parseContentRange(s string) (start, total int64, err error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, fmt.Errorf("xhttp: invalid content range: %s", s)
+	}
+
+	ss := strings.SplitN(strings.TrimSpace(s[6:]), "/", 2)
+	if len(ss) != 2 {
+		return 0, 0, fmt.Errorf("xhttp: invalid content range: %s", s)
+	}
+
+	total = -1
+	if ss[1] != "*" {
+		total, err = strconv.ParseInt(ss[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("xhttp: invalid content range: %s", s)
+		}
+	}
+
+	if ss[0] == "*" {
+		return -1, total, nil
+	}
+
+	start, err = strconv.ParseInt(strings.SplitN(ss[0], "-", 2)[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("xhttp: invalid content range: %s", s)
+	}
+
+	return start, total, nil
+}
+
+// verify returns error if file digest not matched
+func (c Checksum) verify(fpath string) error {
+	var h xhash.Hashx
+	var err error
+
+	switch strings.ToLower(strings.TrimSpace(c.Method)) {
+	case "md5":
+		h, err = xhash.FileMd5(fpath)
+	case "sha1":
+		h, err = xhash.FileSha1(fpath)
+	case "sha256":
+		h, err = xhash.FileSha256(fpath)
+	default:
+		return fmt.Errorf("xhttp: not supported checksum method: %s", c.Method)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	sum := h.Hex()
+	if sum != strings.ToLower(strings.TrimSpace(c.Value)) {
+		return fmt.Errorf("xhttp: checksum not matched, expect %s but got %s", c.Value, sum)
+	}
+
+	return nil
+}
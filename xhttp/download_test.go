@@ -0,0 +1,145 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xfile"
+	"github.com/likexian/gokit/xhash"
+)
+
+func TestDownload(t *testing.T) {
+	defer os.RemoveAll("tmp-download")
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := xhash.Sha256(data).Hex()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/norange" {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "data.bin", time.Now(), bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	req := New()
+	ctx := context.Background()
+
+	// fresh download with checksum
+	size, err := req.Download(ctx, ts.URL+"/data.bin", "tmp-download/data.bin", Checksum{"sha256", sum})
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(data)))
+	assert.False(t, xfile.Exists("tmp-download/data.bin"+downloadSuffix))
+	fs, err := xfile.Size("tmp-download/data.bin")
+	assert.Nil(t, err)
+	assert.Equal(t, fs, size)
+
+	// target exists
+	_, err = req.Download(ctx, ts.URL+"/data.bin", "tmp-download/data.bin")
+	assert.NotNil(t, err)
+
+	// resume from partial file
+	err = xfile.Write("tmp-download/resume.bin"+downloadSuffix, data[:3000])
+	assert.Nil(t, err)
+	last := Progress{}
+	size, err = req.Download(ctx, ts.URL+"/data.bin", "tmp-download/resume.bin", Checksum{"SHA256", sum},
+		ProgressFunc(func(p Progress) {
+			last = p
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(data)))
+	assert.Equal(t, last.Current, int64(len(data)))
+	assert.Equal(t, last.Total, int64(len(data)))
+	h, err := xhash.FileSha256("tmp-download/resume.bin")
+	assert.Nil(t, err)
+	assert.Equal(t, h.Hex(), sum)
+
+	// range header not leaked
+	rsp, err := req.Get(ctx, ts.URL+"/data.bin")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 200)
+
+	// partial file is already completed
+	err = xfile.Write("tmp-download/done.bin"+downloadSuffix, data)
+	assert.Nil(t, err)
+	size, err = req.Download(ctx, ts.URL+"/data.bin", "tmp-download/done.bin", Checksum{"sha256", sum})
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(data)))
+
+	// partial file is already completed with http error mode
+	err = xfile.Write("tmp-download/done-error.bin"+downloadSuffix, data)
+	assert.Nil(t, err)
+	size, err = New().EnableHTTPError(true).Download(ctx, ts.URL+"/data.bin", "tmp-download/done-error.bin")
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(data)))
+
+	// server not support range
+	err = xfile.Write("tmp-download/norange.bin"+downloadSuffix, []byte("garbage"))
+	assert.Nil(t, err)
+	size, err = req.Download(ctx, ts.URL+"/norange", "tmp-download/norange.bin", Checksum{"sha256", sum})
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(data)))
+
+	// checksum not matched
+	_, err = req.Download(ctx, ts.URL+"/data.bin", "tmp-download/bad.bin", Checksum{"md5", "bad"})
+	assert.NotNil(t, err)
+	assert.False(t, xfile.Exists("tmp-download/bad.bin"))
+	assert.False(t, xfile.Exists("tmp-download/bad.bin"+downloadSuffix))
+
+	_, err = req.Download(ctx, ts.URL+"/data.bin", "tmp-download/bad.bin", Checksum{"crc32", "bad"})
+	assert.NotNil(t, err)
+
+	// default request
+	size, err = Download(ctx, ts.URL+"/data.bin", "tmp-download/", Checksum{"sha1", xhash.Sha1(data).Hex()})
+	assert.Nil(t, err)
+	assert.Equal(t, size, int64(len(data)))
+	assert.True(t, xfile.Exists("tmp-download/index.html"))
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, total, err := parseContentRange("bytes 100-199/1000")
+	assert.Nil(t, err)
+	assert.Equal(t, start, int64(100))
+	assert.Equal(t, total, int64(1000))
+
+	start, total, err = parseContentRange("bytes 100-199/*")
+	assert.Nil(t, err)
+	assert.Equal(t, start, int64(100))
+	assert.Equal(t, total, int64(-1))
+
+	start, total, err = parseContentRange("bytes */1000")
+	assert.Nil(t, err)
+	assert.Equal(t, start, int64(-1))
+	assert.Equal(t, total, int64(1000))
+
+	for _, v := range []string{"", "bytes", "items 1-2/3", "bytes 1-2", "bytes x-2/3", "bytes 1-2/x"} {
+		_, _, err = parseContentRange(v)
+		assert.NotNil(t, err)
+	}
+}
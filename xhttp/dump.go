@@ -0,0 +1,444 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/likexian/gokit/xjson"
+	"github.com/likexian/gokit/xlog"
+)
+
+// defaultDumpSize is default max body size of dump
+const defaultDumpSize = 64 * 1024
+
+// dumpTruncated is appended to truncated body of dump
+const dumpTruncated = "...(truncated)"
+
+// DumpInfo storing structured dump of request and response, the secrets are redacted
+type DumpInfo struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	StatusCode     int         `json:"status_code"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+	SendTime       int64       `json:"send_time"`
+	Retries        int         `json:"retries"`
+	Attempts       []Attempt   `json:"attempts,omitempty"`
+	CacheStatus    string      `json:"cache_status,omitempty"`
+	Error          string      `json:"error,omitempty"`
+}
+
+// dumpReader is reader replaying the peeked data
+type dumpReader struct {
+	io.Reader
+	io.Closer
+}
+
+// SetDumpLimit set max body size of dump, the exceeded body is truncated, 0 is the default 64KB
+func (r *Request) SetDumpLimit(size int64) *Request {
+	r.Dumping.MaxBody = size
+	return r
+}
+
+// RedactDumpHeader add headers redacted in dump,
+// Authorization, Proxy-Authorization, Cookie and Set-Cookie headers are redacted by default
+func (r *Request) RedactDumpHeader(names ...string) *Request {
+	r.Dumping.RedactHeaders = append(r.Dumping.RedactHeaders, names...)
+	return r
+}
+
+// RedactDumpQuery add query params redacted in dump
+func (r *Request) RedactDumpQuery(names ...string) *Request {
+	r.Dumping.RedactQuery = append(r.Dumping.RedactQuery, names...)
+	return r
+}
+
+// RedactDumpField add fields redacted in dump body, path of JSON field is dot(.) separated,
+// array is walked through, the last part of path is used for form body and truncated JSON
+func (r *Request) RedactDumpField(paths ...string) *Request {
+	r.Dumping.RedactFields = append(r.Dumping.RedactFields, paths...)
+	return r
+}
+
+// SetDumpLogger set logger of dump, the structured dump is written as JSON after every request
+func (r *Request) SetDumpLogger(logger *xlog.Logger) *Request {
+	r.Dumping.Logger = logger
+	return r
+}
+
+// bodyLimit returns max body size of dump, defaultDumpSize is used if MaxBody is not set
+func (d *Dumping) bodyLimit() int64 {
+	if d.MaxBody > 0 {
+		return d.MaxBody
+	}
+
+	return defaultDumpSize
+}
+
+// clone returns a copy of dumping setting
+func (d Dumping) clone() Dumping {
+	d.RedactHeaders = append([]string{}, d.RedactHeaders...)
+	d.RedactQuery = append([]string{}, d.RedactQuery...)
+	d.RedactFields = append([]string{}, d.RedactFields...)
+	return d
+}
+
+// dumpRequest dump the request to response, the body is replayed for sending,
+// the dump is updated by dumpSent after sending
+func (d *Dumping) dumpRequest(req *http.Request, s *Response) {
+	info := &DumpInfo{
+		Method:        req.Method,
+		URL:           d.redactURL(req.URL).String(),
+		RequestHeader: d.redactHeader(req.Header),
+	}
+	s.DumpInfo = info
+
+	if d.DumpBody && req.Body != nil && req.Body != http.NoBody {
+		body, err := d.requestBody(req)
+		if err != nil {
+			return
+		}
+		info.RequestBody = d.redactBody(req.Header, body)
+	}
+
+	b, err := d.rawRequest(req, info)
+	if err == nil {
+		s.Dumping = append(s.Dumping, b)
+	}
+}
+
+// dumpSent update the request dump by the request sent by client, it has the cookies of jar
+// and the headers set by auth provider, signer and interceptors, the body dumped before is kept
+func (d *Dumping) dumpSent(req *http.Request, s *Response) {
+	if s.DumpInfo == nil || len(s.Dumping) == 0 {
+		return
+	}
+
+	info := s.DumpInfo
+	info.Method = req.Method
+	info.URL = d.redactURL(req.URL).String()
+	info.RequestHeader = d.redactHeader(req.Header)
+
+	b, err := d.rawRequest(req, info)
+	if err == nil {
+		s.Dumping[0] = b
+	}
+}
+
+// rawRequest returns http dump of request with the redacted url, header and body of info,
+// the context is dropped so the trace of sent request is not called by dumping
+func (d *Dumping) rawRequest(req *http.Request, info *DumpInfo) ([]byte, error) {
+	dr := *req.WithContext(context.Background())
+	dr.URL = d.redactURL(req.URL)
+	dr.Header = info.RequestHeader
+	dr.GetBody = nil
+
+	if req.Body != nil && req.Body != http.NoBody {
+		dr.Body = ioutil.NopCloser(strings.NewReader(""))
+		if d.DumpBody {
+			dr.Body = ioutil.NopCloser(strings.NewReader(info.RequestBody))
+			dr.ContentLength = int64(len(info.RequestBody))
+		}
+	}
+
+	return httputil.DumpRequestOut(&dr, d.DumpBody)
+}
+
+// dumpResponse dump the response to response, the body is replayed for reading
+func (d *Dumping) dumpResponse(s *Response) {
+	if s.DumpInfo == nil {
+		return
+	}
+
+	info := s.DumpInfo
+	info.StatusCode = s.Response.StatusCode
+	info.ResponseHeader = d.redactHeader(s.Response.Header)
+
+	dr := *s.Response
+	dr.Header = info.ResponseHeader
+	dr.Body = http.NoBody
+	dr.TransferEncoding = nil
+
+	if d.DumpBody {
+		body, rest, err := peekBody(s.Response.Body, d.bodyLimit())
+		s.Response.Body = rest
+		if err != nil {
+			return
+		}
+		info.ResponseBody = d.redactBody(s.Response.Header, body)
+		dr.Body = ioutil.NopCloser(strings.NewReader(info.ResponseBody))
+		dr.ContentLength = int64(len(info.ResponseBody))
+	}
+
+	b, err := httputil.DumpResponse(&dr, d.DumpBody)
+	if err == nil {
+		s.Dumping = append(s.Dumping, b)
+	}
+}
+
+// logDump write the structured dump to logger
+func (d *Dumping) logDump(s *Response, err error) {
+	if d.Logger == nil || s.DumpInfo == nil {
+		return
+	}
+
+	info := s.DumpInfo
+	info.SendTime = s.Tracing.SendTime
+	info.Retries = s.Tracing.Retries
+	info.Attempts = append([]Attempt{}, s.Tracing.Attempts...)
+	info.CacheStatus = s.Tracing.CacheStatus
+	for i := range info.Attempts {
+		info.Attempts[i].Error = strings.Replace(info.Attempts[i].Error, s.URL.String(), info.URL, -1)
+	}
+
+	if err != nil {
+		info.Error = strings.Replace(err.Error(), s.URL.String(), info.URL, -1)
+	}
+
+	text, e := xjson.Dumps(info)
+	if e == nil {
+		d.Logger.Info("xhttp dump: %s", text)
+	}
+}
+
+// requestBody returns peeked request body, the body of request is reset
+func (d *Dumping) requestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		body, rest, err := peekBody(req.Body, d.bodyLimit())
+		req.Body = rest
+		return body, err
+	}
+
+	rc, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := peekBody(rc, d.bodyLimit())
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err = req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body.Close()
+	req.Body = rc
+
+	return body, nil
+}
+
+// redactHeader returns copy of header with the secret headers redacted
+func (d *Dumping) redactHeader(header http.Header) http.Header {
+	h := cloneHeader(header)
+	for _, v := range d.RedactHeaders {
+		k := http.CanonicalHeaderKey(v)
+		for i := range h[k] {
+			h[k][i] = redactedValue
+		}
+	}
+
+	return h
+}
+
+// isRedactedHeader returns if the header is redacted
+func (d *Dumping) isRedactedHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, v := range d.RedactHeaders {
+		if http.CanonicalHeaderKey(v) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactURL returns copy of url with the secret query params and password redacted
+func (d *Dumping) redactURL(u *url.URL) *url.URL {
+	n := *u
+	if p, ok := u.User.Password(); ok && p != "" {
+		n.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+
+	if len(d.RedactQuery) == 0 || u.RawQuery == "" {
+		return &n
+	}
+
+	query := u.Query()
+	redacted := false
+	for _, v := range d.RedactQuery {
+		if vv, ok := query[v]; ok {
+			for i := range vv {
+				vv[i] = redactedValue
+			}
+			redacted = true
+		}
+	}
+
+	if redacted {
+		n.RawQuery = query.Encode()
+	}
+
+	return &n
+}
+
+// redactBody returns body with the secret fields redacted, body exceeded MaxBody is truncated
+func (d *Dumping) redactBody(header http.Header, body []byte) string {
+	limit := d.bodyLimit()
+	truncated := int64(len(body)) > limit
+	if truncated {
+		body = body[:limit]
+	}
+
+	text := string(body)
+	if len(d.RedactFields) > 0 {
+		if isMediaType(header.Get("Content-Type"), "x-www-form-urlencoded") {
+			text = d.redactForm(text)
+		} else if !truncated && isJSONBody(body) {
+			text = d.redactJSON(body)
+		} else {
+			text = d.redactText(text)
+		}
+	}
+
+	if truncated {
+		text += dumpTruncated
+	}
+
+	return text
+}
+
+// redactJSON returns JSON body with the secret fields redacted
+func (d *Dumping) redactJSON(body []byte) string {
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return d.redactText(string(body))
+	}
+
+	for _, v := range d.RedactFields {
+		redactPath(data, strings.Split(v, "."))
+	}
+
+	text, err := xjson.Dumps(data)
+	if err != nil {
+		return d.redactText(string(body))
+	}
+
+	return text
+}
+
+// redactForm returns form body with the secret fields redacted
+func (d *Dumping) redactForm(text string) string {
+	form, err := url.ParseQuery(text)
+	if err != nil {
+		return d.redactText(text)
+	}
+
+	redacted := false
+	for _, v := range d.RedactFields {
+		if vv, ok := form[lastPath(v)]; ok {
+			for i := range vv {
+				vv[i] = redactedValue
+			}
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return text
+	}
+
+	return form.Encode()
+}
+
+// redactText returns text with the values of JSON like fields redacted, it is used for not parsable body
+func (d *Dumping) redactText(text string) string {
+	for _, v := range d.RedactFields {
+		re := regexp.MustCompile(`("` + regexp.QuoteMeta(lastPath(v)) + `"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`)
+		text = re.ReplaceAllString(text, `${1}"`+redactedValue+`"`)
+	}
+
+	return text
+}
+
+// redactPath redact value of path in JSON data, array is walked through
+func redactPath(data interface{}, path []string) {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, vv := range v {
+			redactPath(vv, path)
+		}
+	case map[string]interface{}:
+		vv, ok := v[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			v[path[0]] = redactedValue
+			return
+		}
+		redactPath(vv, path[1:])
+	}
+}
+
+// lastPath returns the last part of dot(.) separated path
+func lastPath(path string) string {
+	ls := strings.Split(path, ".")
+	return ls[len(ls)-1]
+}
+
+// isJSONBody returns if body looks like JSON
+func isJSONBody(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
+
+// peekBody returns at most n+1 bytes of body and a reader replaying them, n <= 0 is not limited
+func peekBody(body io.ReadCloser, n int64) ([]byte, io.ReadCloser, error) {
+	var r io.Reader = body
+	if n > 0 {
+		r = io.LimitReader(body, n+1)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		err = fmt.Errorf("xhttp: read body for dump failed: %s", err.Error())
+	}
+
+	return data, dumpReader{io.MultiReader(bytes.NewReader(data), body), body}, err
+}
@@ -0,0 +1,469 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/likexian/gokit/xfile"
+	"github.com/likexian/gokit/xjson"
+	"github.com/likexian/gokit/xtime"
+)
+
+// harVersion is the version of HTTP Archive format
+const harVersion = "1.2"
+
+// harTimeFormat is the time format of HAR startedDateTime
+const harTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// HAR is recorder of HTTP Archive 1.2, it is shared by cloned requests, the entries can be opened in
+// browser devtools, the request is recorded as sent, with the cookies of jar and the headers set by auth,
+// signer and interceptors, the secrets are redacted by the dump settings of request, see RedactDumpHeader,
+// response body is peeked up to the dump limit and replayed for reading, request body is recorded only
+// if it is replayable, body of event stream and NDJSON is not recorded, MaxEntries is the max number
+// of recent entries kept, 0 is not limited
+type HAR struct {
+	MaxEntries int
+	mutex      sync.Mutex
+	entries    []*HAREntry
+}
+
+// HARLog storing log of HTTP Archive
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+// HARCreator storing creator of HTTP Archive
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry storing an exchange of request and response, time is in milliseconds,
+// the request error is in _error and the response status is 0 if there is no response
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	CacheStatus     string      `json:"_cacheStatus,omitempty"`
+	Retries         int         `json:"_retries,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+// HARRequest storing request of entry
+type HARRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []HARCookie  `json:"cookies"`
+	Headers     []HARPair    `json:"headers"`
+	QueryString []HARPair    `json:"queryString"`
+	PostData    *HARPostData `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+// HARResponse storing response of entry
+type HARResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []HARCookie `json:"cookies"`
+	Headers     []HARPair   `json:"headers"`
+	Content     HARContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// HARPair storing name and value of header and query param
+type HARPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARCookie storing cookie of request and response
+type HARCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+// HARPostData storing body of request
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// HARContent storing body of response, text is base64 encoded if not valid utf8
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings storing phase timing of the last attempt, ssl is included in connect,
+// blocked includes the retries and waiting before it, -1 is not applicable
+type HARTimings struct {
+	Blocked int64 `json:"blocked"`
+	DNS     int64 `json:"dns"`
+	Connect int64 `json:"connect"`
+	SSL     int64 `json:"ssl"`
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+// NewHAR returns a new HAR recorder
+func NewHAR() *HAR {
+	return &HAR{
+		entries: []*HAREntry{},
+	}
+}
+
+// SetHAR set HAR recorder of request, every request is recorded as an entry, redirects followed by client are in the same entry
+func (r *Request) SetHAR(har *HAR) *Request {
+	r.HAR = har
+	return r
+}
+
+// Entries returns the recorded entries
+func (h *HAR) Entries() []*HAREntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]*HAREntry{}, h.entries...)
+}
+
+// Reset remove all the recorded entries
+func (h *HAR) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.entries = []*HAREntry{}
+}
+
+// Dumps returns the HAR document in JSON
+func (h *HAR) Dumps() (string, error) {
+	doc := map[string]HARLog{
+		"log": {
+			Version: harVersion,
+			Creator: HARCreator{
+				Name:    "xhttp",
+				Version: Version(),
+			},
+			Entries: h.Entries(),
+		},
+	}
+
+	text, err := xjson.PrettyDumps(doc)
+	if err != nil {
+		return "", fmt.Errorf("xhttp: encode har failed: %s", err.Error())
+	}
+
+	return text, nil
+}
+
+// Save write the HAR document to file, it is written to temp file and renamed
+func (h *HAR) Save(fpath string) error {
+	text, err := h.Dumps()
+	if err != nil {
+		return err
+	}
+
+	tpath := fpath + ".tmp"
+	err = xfile.WriteText(tpath, text)
+	if err != nil {
+		return fmt.Errorf("xhttp: write har failed: %s", err.Error())
+	}
+
+	return os.Rename(tpath, fpath)
+}
+
+// record add the request and response as an entry, the response body is replayed for reading
+func (h *HAR) record(req *http.Request, s *Response, d *Dumping, startAt int64, err error) {
+	e := &HAREntry{
+		StartedDateTime: time.Unix(0, startAt*int64(time.Millisecond)).Format(harTimeFormat),
+		Time:            xtime.Ms() - startAt,
+		Request:         harRequest(sentRequest(req, s), d),
+		Response: HARResponse{
+			Cookies:     []HARCookie{},
+			Headers:     []HARPair{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		CacheStatus: s.Tracing.CacheStatus,
+	}
+
+	if s.Tracing.Retries > 0 {
+		e.Retries = s.Tracing.Retries
+	}
+
+	if s.Response != nil {
+		e.Response = harResponse(s, d)
+	}
+
+	if err != nil {
+		e.Error = strings.Replace(err.Error(), s.URL.String(), e.Request.URL, -1)
+	}
+
+	if n := len(s.Tracing.Attempts); n > 0 {
+		a := s.Tracing.Attempts[n-1]
+		if host, _, err := net.SplitHostPort(a.RemoteAddr); err == nil {
+			e.ServerIPAddress = host
+		}
+		e.Timings = harTimings(a, req.URL.Scheme == "https", e.Time)
+	} else {
+		e.Timings = HARTimings{Blocked: e.Time, DNS: -1, Connect: -1, SSL: -1}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.entries = append(h.entries, e)
+	if h.MaxEntries > 0 && len(h.entries) > h.MaxEntries {
+		h.entries = append([]*HAREntry{}, h.entries[len(h.entries)-h.MaxEntries:]...)
+	}
+}
+
+// sentRequest returns the first request sent by client for the response, it has the cookies of jar and
+// the headers set by auth provider, signer and interceptors, req is returned if there is no response
+func sentRequest(req *http.Request, s *Response) *http.Request {
+	if s.Response == nil || s.Response.Request == nil {
+		return req
+	}
+
+	sent := s.Response.Request
+	for sent.Response != nil && sent.Response.Request != nil {
+		sent = sent.Response.Request
+	}
+
+	return sent
+}
+
+// harRequest returns HAR request with the secrets redacted
+func harRequest(req *http.Request, d *Dumping) HARRequest {
+	u := d.redactURL(req.URL)
+	r := HARRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies(), d.isRedactedHeader("Cookie")),
+		Headers:     harHeaders(d.redactHeader(req.Header)),
+		QueryString: []HARPair{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+
+	if r.HTTPVersion == "" {
+		r.HTTPVersion = "HTTP/1.1"
+	}
+
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range query[k] {
+			r.QueryString = append(r.QueryString, HARPair{Name: k, Value: v})
+		}
+	}
+
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body, _, err := peekBody(rc, d.bodyLimit())
+			rc.Close()
+			if err == nil {
+				r.PostData = &HARPostData{
+					MimeType: req.Header.Get("Content-Type"),
+					Text:     d.redactBody(req.Header, body),
+				}
+			}
+		}
+	}
+
+	return r
+}
+
+// harResponse returns HAR response with the secrets redacted, the body is replayed for reading
+func harResponse(s *Response, d *Dumping) HARResponse {
+	rsp := s.Response
+	r := HARResponse{
+		Status:      rsp.StatusCode,
+		StatusText:  strings.TrimPrefix(rsp.Status, strconv.Itoa(rsp.StatusCode)+" "),
+		HTTPVersion: rsp.Proto,
+		Cookies:     harCookies(rsp.Cookies(), d.isRedactedHeader("Set-Cookie")),
+		Headers:     harHeaders(d.redactHeader(rsp.Header)),
+		Content: HARContent{
+			Size:     rsp.ContentLength,
+			MimeType: rsp.Header.Get("Content-Type"),
+		},
+		RedirectURL: rsp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    rsp.ContentLength,
+	}
+
+	if r.StatusText == "" {
+		r.StatusText = http.StatusText(rsp.StatusCode)
+	}
+
+	ct := r.Content.MimeType
+	if rsp.Body == nil || rsp.Body == http.NoBody || isMediaType(ct, "event-stream") ||
+		isMediaType(ct, "ndjson") || isMediaType(ct, "x-ndjson") {
+		return r
+	}
+
+	limit := d.bodyLimit()
+	body, rest, err := peekBody(rsp.Body, limit)
+	rsp.Body = rest
+	if err != nil {
+		return r
+	}
+
+	truncated := int64(len(body)) > limit
+	if !truncated {
+		r.Content.Size = int64(len(body))
+	}
+
+	if isTextBody(body, truncated) {
+		r.Content.Text = d.redactBody(rsp.Header, body)
+	} else {
+		if truncated {
+			body = body[:limit]
+		}
+		r.Content.Text = base64.StdEncoding.EncodeToString(body)
+		r.Content.Encoding = "base64"
+	}
+
+	return r
+}
+
+// harTimings returns HAR timings of attempt, the time not in phases of attempt is blocked
+func harTimings(a Attempt, isTLS bool, total int64) HARTimings {
+	t := HARTimings{
+		DNS:     -1,
+		Connect: -1,
+		SSL:     -1,
+		Wait:    a.ServerTime,
+	}
+
+	if !a.ConnReused {
+		t.DNS = a.DNSTime
+		t.Connect = a.ConnectTime
+		if isTLS {
+			t.SSL = a.TLSTime
+			t.Connect += a.TLSTime
+		}
+	}
+
+	t.Send = a.FirstByteTime - a.ServerTime - a.DNSTime - a.ConnectTime - a.TLSTime
+	if t.Send < 0 {
+		t.Send = 0
+	}
+
+	t.Blocked = total - t.Send - t.Wait
+	if t.DNS > 0 {
+		t.Blocked -= t.DNS
+	}
+	if t.Connect > 0 {
+		t.Blocked -= t.Connect
+	}
+	if t.Blocked < 0 {
+		t.Blocked = 0
+	}
+
+	return t
+}
+
+// harHeaders returns HAR headers sorted by name
+func harHeaders(header http.Header) []HARPair {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := []HARPair{}
+	for _, k := range keys {
+		for _, v := range header[k] {
+			pairs = append(pairs, HARPair{Name: k, Value: v})
+		}
+	}
+
+	return pairs
+}
+
+// harCookies returns HAR cookies, the values are redacted if redacted is true
+func harCookies(cookies []*http.Cookie, redacted bool) []HARCookie {
+	result := []HARCookie{}
+	for _, c := range cookies {
+		v := HARCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if redacted {
+			v.Value = redactedValue
+		}
+		if !c.Expires.IsZero() {
+			v.Expires = c.Expires.Format(harTimeFormat)
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// isTextBody returns if body is valid utf8, the last rune of truncated body may be incomplete
+func isTextBody(body []byte, truncated bool) bool {
+	if truncated && len(body) > utf8.UTFMax {
+		body = body[:len(body)-utf8.UTFMax]
+	}
+
+	return utf8.Valid(body)
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xjson"
+)
+
+func TestHARTimings(t *testing.T) {
+	a := Attempt{DNSTime: 2, ConnectTime: 3, TLSTime: 4, ServerTime: 10, FirstByteTime: 20}
+	assert.Equal(t, harTimings(a, true, 25), HARTimings{Blocked: 5, DNS: 2, Connect: 7, SSL: 4, Send: 1, Wait: 10})
+
+	a = Attempt{DNSTime: 2, ConnectTime: 3, ServerTime: 10, FirstByteTime: 20}
+	assert.Equal(t, harTimings(a, false, 25), HARTimings{Blocked: 5, DNS: 2, Connect: 3, SSL: -1, Send: 5, Wait: 10})
+
+	a = Attempt{ConnReused: true, ServerTime: 10, FirstByteTime: 12}
+	assert.Equal(t, harTimings(a, true, 10), HARTimings{Blocked: 0, DNS: -1, Connect: -1, SSL: -1, Send: 2, Wait: 10})
+}
+
+func TestIsTextBody(t *testing.T) {
+	assert.True(t, isTextBody([]byte("likexian"), false))
+	assert.False(t, isTextBody([]byte{0xff, 0xfe, 0x00}, false))
+	assert.False(t, isTextBody([]byte("中文")[:4], false))
+	assert.True(t, isTextBody([]byte("likexian中文")[:12], true))
+}
+
+func TestHAR(t *testing.T) {
+	defer os.RemoveAll("tmp-har")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/binary":
+			w.Write([]byte{0xff, 0xfe, 0x00})
+		case "/redirect":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/cache":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("cached"))
+		default:
+			body, _ := ioutil.ReadAll(r.Body)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret", Path: "/", HttpOnly: true})
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"token": "secret", "size": %d}`, len(body))
+		}
+	}))
+	defer ts.Close()
+
+	har := NewHAR()
+	req := New().SetHAR(har).RedactDumpQuery("key").RedactDumpField("password", "token")
+	ctx := context.Background()
+
+	rsp, err := req.Post(ctx, ts.URL+"/post?key=secret&v=1", JsonParam{"password": "secret", "name": "likexian"},
+		Header{"Authorization": "Bearer secret", "Cookie": "session=secret"})
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Contains(t, text, `"token": "secret"`)
+
+	entries := har.Entries()
+	assert.Equal(t, len(entries), 1)
+
+	e := entries[0]
+	assert.Equal(t, e.Request.Method, "POST")
+	assert.Equal(t, e.Request.URL, ts.URL+"/post?key=REDACTED&v=1")
+	assert.Equal(t, e.Request.HTTPVersion, "HTTP/1.1")
+	assert.Equal(t, e.Request.QueryString, []HARPair{{"key", "REDACTED"}, {"v", "1"}})
+	assert.Contains(t, e.Request.Headers, HARPair{"Authorization", "REDACTED"})
+	assert.Equal(t, e.Request.Cookies, []HARCookie{{Name: "session", Value: "REDACTED"}})
+	assert.Contains(t, e.Request.PostData.MimeType, "application/json")
+	assert.Contains(t, e.Request.PostData.Text, `"password":"REDACTED"`)
+	assert.Contains(t, e.Request.PostData.Text, `"name":"likexian"`)
+	assert.Equal(t, e.Response.Status, http.StatusOK)
+	assert.Equal(t, e.Response.StatusText, "OK")
+	assert.Equal(t, e.Response.HTTPVersion, "HTTP/1.1")
+	assert.Equal(t, e.Response.Cookies, []HARCookie{{Name: "session", Value: "REDACTED", Path: "/", HTTPOnly: true}})
+	assert.Contains(t, e.Response.Headers, HARPair{"Set-Cookie", "REDACTED"})
+	assert.Equal(t, e.Response.Content.MimeType, "application/json")
+	assert.Equal(t, e.Response.Content.Size, int64(len(text)))
+	assert.Contains(t, e.Response.Content.Text, "REDACTED")
+	assert.NotContains(t, e.Response.Content.Text, "secret")
+	assert.Equal(t, e.ServerIPAddress, "127.0.0.1")
+	assert.Equal(t, e.Timings.SSL, int64(-1))
+	assert.True(t, e.Time >= 0)
+
+	cached := req.Clone().EnableCache("GET", 60)
+	for _, v := range []string{"/binary", "/redirect", "/cache", "/cache"} {
+		rsp, err = cached.Get(ctx, ts.URL+v)
+		assert.Nil(t, err)
+		rsp.Close()
+	}
+
+	_, err = req.Get(ctx, "http://127.0.0.1:1/?key=secret")
+	assert.NotNil(t, err)
+
+	entries = har.Entries()
+	assert.Equal(t, len(entries), 6)
+
+	assert.Equal(t, entries[1].Response.Content.Text, "//4A")
+	assert.Equal(t, entries[1].Response.Content.Encoding, "base64")
+	assert.Equal(t, entries[2].Request.URL, ts.URL+"/redirect")
+	assert.Equal(t, entries[2].Response.Status, http.StatusOK)
+	assert.Equal(t, entries[3].CacheStatus, CacheMiss)
+	assert.Equal(t, entries[4].CacheStatus, CacheHit)
+	assert.Equal(t, entries[4].Response.Content.Text, "cached")
+	assert.Equal(t, entries[5].Response.Status, 0)
+	assert.Equal(t, entries[5].Request.URL, "http://127.0.0.1:1/?key=REDACTED")
+	assert.NotEqual(t, entries[5].Error, "")
+	assert.NotContains(t, entries[5].Error, "secret")
+
+	text, err = har.Dumps()
+	assert.Nil(t, err)
+	assert.NotContains(t, text, "secret")
+
+	err = har.Save("tmp-har/test.har")
+	assert.Nil(t, err)
+
+	j, err := xjson.Load("tmp-har/test.har")
+	assert.Nil(t, err)
+	assert.Equal(t, j.Get("log.version").MustString(), "1.2")
+	assert.Equal(t, j.Get("log.creator.name").MustString(), "xhttp")
+	assert.Equal(t, j.Get("log.creator.version").MustString(), Version())
+	assert.Equal(t, j.Get("log.entries").Len(), 6)
+	assert.Equal(t, j.Get("log.entries").Index(0).Get("request").Get("headersSize").MustInt(), -1)
+	assert.True(t, j.Get("log.entries").Index(5).Get("response").Get("cookies").IsArray())
+
+	har.MaxEntries = 2
+	rsp, err = req.Get(ctx, ts.URL+"/binary")
+	assert.Nil(t, err)
+	rsp.Close()
+	entries = har.Entries()
+	assert.Equal(t, len(entries), 2)
+	assert.True(t, strings.HasPrefix(entries[1].Request.URL, ts.URL+"/binary"))
+
+	har.Reset()
+	assert.Equal(t, len(har.Entries()), 0)
+}
+
+func TestHARSentRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	har := NewHAR()
+	req := New().SetHAR(har).SetBearerAuth("secret").RedactDumpHeader("X-Token").
+		ImportCookies(&http.Cookie{Name: "session", Value: "jar", Domain: u.Hostname(), Path: "/"}).
+		AddInterceptor(func(next Sender) Sender {
+			return func(req *http.Request) (*http.Response, error) {
+				sent := req.WithContext(req.Context())
+				sent.Header = http.Header{}
+				for k, v := range req.Header {
+					sent.Header[k] = v
+				}
+				sent.Header.Set("X-Token", "secret")
+				sent.Header.Set("X-Intercepted", "1")
+				return next(sent)
+			}
+		})
+
+	ctx := context.Background()
+	for _, v := range []string{"/", "/redirect"} {
+		rsp, err := req.Get(ctx, ts.URL+v)
+		assert.Nil(t, err)
+		rsp.Close()
+	}
+
+	entries := har.Entries()
+	assert.Equal(t, len(entries), 2)
+	for i, v := range []string{"/", "/redirect"} {
+		e := entries[i]
+		assert.Equal(t, e.Request.URL, ts.URL+v)
+		assert.Contains(t, e.Request.Headers, HARPair{"Authorization", "REDACTED"})
+		assert.Contains(t, e.Request.Headers, HARPair{"X-Token", "REDACTED"})
+		assert.Contains(t, e.Request.Headers, HARPair{"X-Intercepted", "1"})
+		assert.Equal(t, e.Request.Cookies, []HARCookie{{Name: "session", Value: "REDACTED"}})
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Sender is function send http request and returns http response
+type Sender func(*http.Request) (*http.Response, error)
+
+// Interceptor is middleware wrapping the next Sender, it can modify the request
+// before calling next, check the response after, or returns a response without calling next
+type Interceptor func(next Sender) Sender
+
+// BeforeSend is hook called before sending request,
+// returns a not nil response will short-circuit the sending
+type BeforeSend func(*http.Request) (*http.Response, error)
+
+// AfterRecv is hook called after receiving response,
+// returns a not nil response will replace the received one
+type AfterRecv func(*http.Request, *http.Response) (*http.Response, error)
+
+// AddInterceptor add interceptors to request, the first added is the outermost
+func (r *Request) AddInterceptor(interceptors ...Interceptor) *Request {
+	r.Interceptors = append(r.Interceptors, interceptors...)
+	return r
+}
+
+// OnBeforeSend add hook called before sending request
+func (r *Request) OnBeforeSend(fn BeforeSend) *Request {
+	return r.AddInterceptor(func(next Sender) Sender {
+		return func(req *http.Request) (*http.Response, error) {
+			rsp, err := fn(req)
+			if err != nil || rsp != nil {
+				return rsp, err
+			}
+			return next(req)
+		}
+	})
+}
+
+// OnAfterRecv add hook called after receiving response
+func (r *Request) OnAfterRecv(fn AfterRecv) *Request {
+	return r.AddInterceptor(func(next Sender) Sender {
+		return func(req *http.Request) (*http.Response, error) {
+			rsp, err := next(req)
+			if err != nil {
+				return rsp, err
+			}
+			newRsp, err := fn(req, rsp)
+			if err != nil {
+				rsp.Body.Close()
+				return nil, err
+			}
+			if newRsp != nil {
+				return newRsp, nil
+			}
+			return rsp, nil
+		}
+	})
+}
+
+// sender returns client sender wrapped by TLS pinning, proxy pool, signer, auth provider and all interceptors
+func (r *Request) sender(client *http.Client) Sender {
+	send := r.proxy(r.resolve(r.pin(client)))
+	if r.Signer != nil {
+		signer := r.Signer
+		next := send
+		send = func(req *http.Request) (*http.Response, error) {
+			err := signer.Sign(req)
+			if err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+
+	send = r.auth(send)
+
+	for i := len(r.Interceptors) - 1; i >= 0; i-- {
+		send = r.Interceptors[i](send)
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		rsp, err := send(req)
+		if err != nil {
+			return rsp, err
+		}
+		if rsp == nil {
+			return nil, fmt.Errorf("xhttp: interceptor returns nil response")
+		}
+		if rsp.Header == nil {
+			rsp.Header = http.Header{}
+		}
+		if rsp.Body == nil {
+			rsp.Body = http.NoBody
+		}
+		if rsp.Request == nil {
+			rsp.Request = req
+		}
+		return rsp, nil
+	}
+}
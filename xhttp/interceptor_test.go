@@ -0,0 +1,130 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestAddInterceptor(t *testing.T) {
+	req := New()
+	ctx := context.Background()
+
+	steps := []string{}
+	for _, v := range []string{"a", "b"} {
+		name := v
+		req.AddInterceptor(func(next Sender) Sender {
+			return func(r *http.Request) (*http.Response, error) {
+				steps = append(steps, "before-"+name)
+				r.Header.Set("X-Interceptor-"+name, name)
+				rsp, err := next(r)
+				steps = append(steps, "after-"+name)
+				return rsp, err
+			}
+		})
+	}
+
+	rsp, err := req.Do(ctx, "GET", LOCALURL+"get")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, steps, []string{"before-a", "before-b", "after-b", "after-a"})
+
+	json, err := rsp.Json()
+	assert.Nil(t, err)
+	assert.Equal(t, json.Get("headers.X-Interceptor-A.0").MustString(""), "a")
+	assert.Equal(t, json.Get("headers.X-Interceptor-B.0").MustString(""), "b")
+}
+
+func TestOnBeforeSend(t *testing.T) {
+	req := New()
+	ctx := context.Background()
+
+	req.OnBeforeSend(func(r *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(r.URL.Path, "/mock") {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(strings.NewReader("mocked")),
+			}, nil
+		}
+		if strings.HasSuffix(r.URL.Path, "/deny") {
+			return nil, fmt.Errorf("denied")
+		}
+		r.Header.Set("Authorization", "Bearer likexian")
+		return nil, nil
+	})
+
+	rsp, err := req.Do(ctx, "GET", LOCALURL+"mock")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 200)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "mocked")
+	assert.Equal(t, rsp.GetHeader("Server"), "")
+
+	_, err = req.Do(ctx, "GET", LOCALURL+"deny")
+	assert.NotNil(t, err)
+
+	rsp, err = req.Do(ctx, "GET", LOCALURL+"get")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	json, err := rsp.Json()
+	assert.Nil(t, err)
+	assert.Equal(t, json.Get("headers.Authorization.0").MustString(""), "Bearer likexian")
+}
+
+func TestOnAfterRecv(t *testing.T) {
+	req := New()
+	ctx := context.Background()
+
+	req.OnAfterRecv(func(r *http.Request, rsp *http.Response) (*http.Response, error) {
+		if rsp.StatusCode >= 500 {
+			return nil, fmt.Errorf("bad status code: %d", rsp.StatusCode)
+		}
+		if rsp.StatusCode == 404 {
+			rsp.Header.Set("X-Not-Found", "1")
+		}
+		return nil, nil
+	})
+
+	rsp, err := req.Do(ctx, "GET", LOCALURL+"status/404")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 404)
+	assert.Equal(t, rsp.GetHeader("X-Not-Found"), "1")
+
+	_, err = req.Do(ctx, "GET", LOCALURL+"status/503")
+	assert.NotNil(t, err)
+
+	req = New().AddInterceptor(func(next Sender) Sender {
+		return func(r *http.Request) (*http.Response, error) {
+			return nil, nil
+		}
+	})
+	_, err = req.Do(ctx, "GET", LOCALURL)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiting storing rate limit and in-flight cap setting
+type Limiting struct {
+	Rate        float64
+	Burst       int
+	MaxInFlight int
+	PerHost     bool
+	limiter     *limiter
+}
+
+// limiter storing token buckets and in-flight slots by key
+type limiter struct {
+	sync.Mutex
+	buckets  map[string]*bucket
+	inflight map[string]chan struct{}
+}
+
+// bucket is token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limitBody is response body releasing in-flight slot when closed
+type limitBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// SetRateLimit set token bucket rate limit, rate is requests per second and burst is max requests at once,
+// 0 rate is unlimited (default), every attempt of retries takes a token
+func (r *Request) SetRateLimit(rate float64, burst int) *Request {
+	if burst < 1 {
+		burst = 1
+	}
+
+	r.Limiting.Rate = rate
+	r.Limiting.Burst = burst
+	r.Limiting.limiter = newLimiter()
+
+	return r
+}
+
+// SetMaxInFlight set max in-flight requests, 0 is unlimited (default),
+// the slot is taken until the response body is closed
+func (r *Request) SetMaxInFlight(max int) *Request {
+	r.Limiting.MaxInFlight = max
+	r.Limiting.limiter = newLimiter()
+	return r
+}
+
+// SetLimitPerHost set rate limit and in-flight cap are counting per host or per request
+func (r *Request) SetLimitPerHost(perHost bool) *Request {
+	r.Limiting.PerHost = perHost
+	r.Limiting.limiter = newLimiter()
+	return r
+}
+
+// newLimiter returns a new limiter
+func newLimiter() *limiter {
+	return &limiter{
+		buckets:  map[string]*bucket{},
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// limitWait wait for in-flight slot and rate token, returns the func for releasing slot
+func (r *Request) limitWait(ctx context.Context, host string) (release func(), err error) {
+	release = func() {}
+	l := r.Limiting.limiter
+	if l == nil || (r.Limiting.Rate <= 0 && r.Limiting.MaxInFlight <= 0) {
+		return
+	}
+
+	key := ""
+	if r.Limiting.PerHost {
+		key = host
+	}
+
+	if r.Limiting.MaxInFlight > 0 {
+		slots := l.slots(key, r.Limiting.MaxInFlight)
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return release, ctx.Err()
+		}
+		once := sync.Once{}
+		release = func() {
+			once.Do(func() {
+				<-slots
+			})
+		}
+	}
+
+	if r.Limiting.Rate > 0 {
+		wait := l.reserve(key, r.Limiting.Rate, r.Limiting.Burst)
+		err = sleepContext(ctx, wait)
+		if err != nil {
+			l.cancel(key, r.Limiting.Burst)
+			release()
+		}
+	}
+
+	return
+}
+
+// slots returns in-flight slots of key
+func (l *limiter) slots(key string, max int) chan struct{} {
+	l.Lock()
+	defer l.Unlock()
+
+	slots, ok := l.inflight[key]
+	if !ok {
+		slots = make(chan struct{}, max)
+		l.inflight[key] = slots
+	}
+
+	return slots
+}
+
+// reserve takes a token from bucket of key, returns the duration to wait for it
+func (l *limiter) reserve(key string, rate float64, burst int) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+
+	b.last = now
+	b.tokens -= 1
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// cancel returns the reserved token to bucket of key
+func (l *limiter) cancel(key string, burst int) {
+	l.Lock()
+	defer l.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens += 1
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+}
+
+// Close close the body and release the in-flight slot
+func (b *limitBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := newLimiter()
+
+	assert.Equal(t, l.reserve("", 10, 2), time.Duration(0))
+	assert.Equal(t, l.reserve("", 10, 2), time.Duration(0))
+
+	wait := l.reserve("", 10, 2)
+	assert.Gt(t, wait, 90*time.Millisecond)
+	assert.Le(t, wait, 100*time.Millisecond)
+
+	l.cancel("", 2)
+	wait = l.reserve("", 10, 2)
+	assert.Gt(t, wait, 90*time.Millisecond)
+	assert.Le(t, wait, 100*time.Millisecond)
+
+	assert.Equal(t, l.reserve("likexian.com", 10, 2), time.Duration(0))
+}
+
+func TestSetRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	req := New().SetRateLimit(20, 1)
+	assert.Equal(t, req.Limiting.Rate, float64(20))
+	assert.Equal(t, req.Limiting.Burst, 1)
+
+	startAt := time.Now()
+	waitTime := int64(0)
+	for i := 0; i < 5; i++ {
+		rsp, err := req.Get(context.Background(), ts.URL)
+		assert.Nil(t, err)
+		rsp.Close()
+		assert.Equal(t, len(rsp.Tracing.Attempts), 1)
+		assert.Equal(t, rsp.Tracing.WaitTime, rsp.Tracing.Attempts[0].WaitTime)
+		waitTime += rsp.Tracing.WaitTime
+	}
+
+	assert.Ge(t, time.Since(startAt), 190*time.Millisecond)
+	assert.Ge(t, waitTime, int64(150))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req = New().SetRateLimit(1, 1)
+	rsp, err := req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	rsp.Close()
+	_, err = req.Get(ctx, ts.URL)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
+
+func TestSetRateLimitPerHost(t *testing.T) {
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts1.Close()
+
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts2.Close()
+
+	req := New().SetRateLimit(1, 1).SetLimitPerHost(true)
+	for _, v := range []string{ts1.URL, ts2.URL} {
+		rsp, err := req.Get(context.Background(), v)
+		assert.Nil(t, err)
+		rsp.Close()
+		assert.Equal(t, rsp.Tracing.WaitTime, int64(0))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := req.Get(ctx, ts1.URL)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
+
+func TestSetMaxInFlight(t *testing.T) {
+	var current, max int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+	req := New().SetMaxInFlight(2)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := req.Get(context.Background(), ts.URL)
+			assert.Nil(t, err)
+			rsp.Close()
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, atomic.LoadInt32(&max), int32(2))
+
+	rsp, err := req.Get(context.Background(), ts.URL)
+	assert.Nil(t, err)
+	defer rsp.Close()
+	rsp, err = req.Get(context.Background(), ts.URL)
+	assert.Nil(t, err)
+	defer rsp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = req.Get(ctx, ts.URL)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/likexian/gokit/xjson"
+)
+
+// DefaultBuckets is default latency histogram buckets in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metric types
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// Metrics storing client metrics of requests, it is shared by cloned requests, the metrics are
+// rendered in Prometheus text format by ServeHTTP and WriteTo, and in JSON by String for expvar,
+// the host label is the host of url, so avoid using it for unbounded hosts
+type Metrics struct {
+	Namespace string
+	Buckets   []float64
+	mutex     sync.Mutex
+	families  []*metricFamily
+	requests  *metricFamily
+	durations *metricFamily
+	retries   *metricFamily
+	caches    *metricFamily
+	inflight  *metricFamily
+}
+
+// metricFamily storing series of a metric
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*metricSeries
+}
+
+// metricSeries storing value of a metric with labels
+type metricSeries struct {
+	values  []string
+	value   float64
+	buckets []int64
+	count   int64
+}
+
+// NewMetrics returns a new client metrics, namespace is the prefix of metric names, it is xhttp if not set
+func NewMetrics(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = "xhttp"
+	}
+
+	m := &Metrics{
+		Namespace: namespace,
+		Buckets:   append([]float64{}, DefaultBuckets...),
+	}
+
+	m.requests = m.family("requests_total", "Total number of requests.",
+		metricCounter, "host", "method", "status")
+	m.durations = m.family("request_duration_seconds", "Latency of requests until response header received.",
+		metricHistogram, "host", "method")
+	m.retries = m.family("retries_total", "Total number of retries.",
+		metricCounter, "host", "method")
+	m.caches = m.family("cache_total", "Total number of cache lookups by status.",
+		metricCounter, "host", "status")
+	m.inflight = m.family("in_flight_requests", "Number of requests in flight.",
+		metricGauge, "host")
+
+	return m
+}
+
+// SetMetrics set client metrics of request
+func (r *Request) SetMetrics(metrics *Metrics) *Request {
+	r.Metrics = metrics
+	return r
+}
+
+// ServeHTTP render the metrics in Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo write the metrics in Prometheus text format to w
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	var buf bytes.Buffer
+	for _, f := range m.families {
+		f.write(&buf, m.Buckets)
+	}
+	m.mutex.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// String returns the metrics in JSON, it makes Metrics an expvar.Var for expvar.Publish
+func (m *Metrics) String() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data := map[string]interface{}{}
+	for _, f := range m.families {
+		series := []interface{}{}
+		for _, s := range f.sorted() {
+			v := map[string]interface{}{}
+			for i, l := range f.labels {
+				v[l] = s.values[i]
+			}
+			if f.kind == metricHistogram {
+				buckets := map[string]int64{}
+				for i, b := range m.Buckets {
+					if i < len(s.buckets) {
+						buckets[formatFloat(b)] = s.buckets[i]
+					}
+				}
+				v["buckets"] = buckets
+				v["count"] = s.count
+				v["sum"] = s.value
+			} else {
+				v["value"] = s.value
+			}
+			series = append(series, v)
+		}
+		data[f.name] = series
+	}
+
+	text, err := xjson.Dumps(data)
+	if err != nil {
+		return "{}"
+	}
+
+	return text
+}
+
+// begin records the request is started
+func (m *Metrics) begin(s *Response) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.inflight.get(s.URL.Host).value++
+}
+
+// observe records the request is done
+func (m *Metrics) observe(s *Response, err error) {
+	status := "error"
+	if s.Response != nil {
+		status = strconv.Itoa(s.Response.StatusCode)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	host := s.URL.Host
+	m.inflight.get(host).value--
+	m.requests.get(host, s.Method, status).value++
+
+	if s.Tracing.CacheStatus != "" {
+		m.caches.get(host, strings.ToLower(s.Tracing.CacheStatus)).value++
+	}
+
+	if s.Tracing.Retries > 0 {
+		m.retries.get(host, s.Method).value += float64(s.Tracing.Retries)
+	}
+
+	seconds := float64(s.Tracing.SendTime) / 1000
+	d := m.durations.get(host, s.Method)
+	if d.buckets == nil {
+		d.buckets = make([]int64, len(m.Buckets))
+	}
+	for i, v := range m.Buckets {
+		if seconds <= v && i < len(d.buckets) {
+			d.buckets[i]++
+		}
+	}
+	d.value += seconds
+	d.count++
+}
+
+// family add a new metric family
+func (m *Metrics) family(name, help, kind string, labels ...string) *metricFamily {
+	f := &metricFamily{
+		name:   m.Namespace + "_" + name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*metricSeries{},
+	}
+
+	m.families = append(m.families, f)
+
+	return f
+}
+
+// get returns series of label values, it is created if not exists
+func (f *metricFamily) get(values ...string) *metricSeries {
+	key := strings.Join(values, "\x00")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{values: values}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// sorted returns series sorted by label values
+func (f *metricFamily) sorted() []*metricSeries {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	series := make([]*metricSeries, 0, len(keys))
+	for _, k := range keys {
+		series = append(series, f.series[k])
+	}
+
+	return series
+}
+
+// write write the metric family in Prometheus text format
+func (f *metricFamily) write(w *bytes.Buffer, buckets []float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	names := append(append([]string{}, f.labels...), "le")
+	for _, s := range f.sorted() {
+		labels := formatLabels(f.labels, s.values)
+		if f.kind != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(s.value))
+			continue
+		}
+
+		for i, v := range buckets {
+			count := int64(0)
+			if i < len(s.buckets) {
+				count = s.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name,
+				formatLabels(names, append(append([]string{}, s.values...), formatFloat(v))), count)
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name,
+			formatLabels(names, append(append([]string{}, s.values...), "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, s.count)
+	}
+}
+
+// formatLabels returns labels in Prometheus text format
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := make([]string, len(names))
+	for i, v := range names {
+		labels[i] = fmt.Sprintf(`%s="%s"`, v, replacer.Replace(values[i]))
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// formatFloat returns float in Prometheus text format
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xjson"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	m := NewMetrics("")
+	m.Buckets = []float64{0.1, 1}
+
+	u, _ := url.Parse("http://www.likexian.com/")
+	s := &Response{Method: "GET", URL: u, Response: &http.Response{StatusCode: 200}}
+	s.Tracing.SendTime = 50
+	s.Tracing.Retries = 2
+	s.Tracing.CacheStatus = CacheMiss
+
+	m.begin(s)
+	m.observe(s, nil)
+
+	s.Tracing.SendTime = 500
+	s.Tracing.Retries = 0
+	m.begin(s)
+	m.observe(s, nil)
+
+	s = &Response{Method: "POST", URL: u}
+	s.Tracing.SendTime = 5000
+	m.begin(s)
+	m.begin(&Response{Method: "GET", URL: &url.URL{Host: `a"b\c`}})
+	m.observe(s, context.Canceled)
+
+	var buf strings.Builder
+	_, err := m.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	text := buf.String()
+	for _, v := range []string{
+		"# TYPE xhttp_requests_total counter",
+		`xhttp_requests_total{host="www.likexian.com",method="GET",status="200"} 2`,
+		`xhttp_requests_total{host="www.likexian.com",method="POST",status="error"} 1`,
+		"# TYPE xhttp_request_duration_seconds histogram",
+		`xhttp_request_duration_seconds_bucket{host="www.likexian.com",method="GET",le="0.1"} 1`,
+		`xhttp_request_duration_seconds_bucket{host="www.likexian.com",method="GET",le="1"} 2`,
+		`xhttp_request_duration_seconds_bucket{host="www.likexian.com",method="GET",le="+Inf"} 2`,
+		`xhttp_request_duration_seconds_sum{host="www.likexian.com",method="GET"} 0.55`,
+		`xhttp_request_duration_seconds_count{host="www.likexian.com",method="GET"} 2`,
+		`xhttp_request_duration_seconds_bucket{host="www.likexian.com",method="POST",le="1"} 0`,
+		`xhttp_request_duration_seconds_bucket{host="www.likexian.com",method="POST",le="+Inf"} 1`,
+		`xhttp_retries_total{host="www.likexian.com",method="GET"} 2`,
+		`xhttp_cache_total{host="www.likexian.com",status="miss"} 2`,
+		"# TYPE xhttp_in_flight_requests gauge",
+		`xhttp_in_flight_requests{host="www.likexian.com"} 0`,
+		`xhttp_in_flight_requests{host="a\"b\\c"} 1`,
+	} {
+		assert.Contains(t, text, v+"\n")
+	}
+
+	j, err := xjson.Loads(m.String())
+	assert.Nil(t, err)
+	assert.Equal(t, j.Get("xhttp_requests_total").Len(), 2)
+	assert.Equal(t, j.Get("xhttp_requests_total").Index(0).Get("status").MustString(), "200")
+	assert.Equal(t, j.Get("xhttp_requests_total").Index(0).Get("value").MustInt(), 2)
+	assert.Equal(t, j.Get("xhttp_request_duration_seconds").Index(0).Get("count").MustInt(), 2)
+	assert.Contains(t, m.String(), `"buckets":{"0.1":1,"1":2}`)
+
+	var v expvar.Var = m
+	assert.Equal(t, v.String(), m.String())
+
+	m = NewMetrics("api")
+	buf.Reset()
+	_, err = m.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "# TYPE api_requests_total counter\n")
+}
+
+func TestMetrics(t *testing.T) {
+	var called int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/retry" && atomic.AddInt32(&called, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("likexian"))
+	}))
+	defer ts.Close()
+
+	metrics := NewMetrics("")
+	policy := NewBackoff()
+	policy.MinSleep = time.Millisecond
+	req := New().SetMetrics(metrics).EnableCache("GET", 60).SetRetries(1, policy)
+
+	ctx := context.Background()
+	for _, v := range []string{"/", "/", "/retry"} {
+		rsp, err := req.Get(ctx, ts.URL+v)
+		assert.Nil(t, err)
+		rsp.Close()
+	}
+
+	_, err := req.Clone().Post(ctx, "http://127.0.0.1:1/")
+	assert.NotNil(t, err)
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	server := httptest.NewServer(metrics)
+	defer server.Close()
+
+	rsp, err := New().Get(ctx, server.URL)
+	assert.Nil(t, err)
+	assert.Contains(t, rsp.GetHeader("Content-Type"), "text/plain; version=0.0.4")
+	text, err := rsp.String()
+	assert.Nil(t, err)
+
+	for _, v := range []string{
+		`xhttp_requests_total{host="` + host + `",method="GET",status="200"} 3`,
+		`xhttp_requests_total{host="127.0.0.1:1",method="POST",status="error"} 1`,
+		`xhttp_request_duration_seconds_count{host="` + host + `",method="GET"} 3`,
+		`xhttp_retries_total{host="` + host + `",method="GET"} 1`,
+		`xhttp_cache_total{host="` + host + `",status="hit"} 1`,
+		`xhttp_cache_total{host="` + host + `",status="miss"} 2`,
+		`xhttp_in_flight_requests{host="` + host + `"} 0`,
+	} {
+		assert.Contains(t, text, v+"\n")
+	}
+}
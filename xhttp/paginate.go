@@ -0,0 +1,421 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/likexian/gokit/xjson"
+)
+
+// Pagination modes
+const (
+	// PageLink is following the rel="next" link of Link header, RFC 8288
+	PageLink = iota
+	// PageCursor is sending the cursor of response as query param of next request
+	PageCursor
+	// PageOffset is sending the number of items received as query param of next request
+	PageOffset
+)
+
+// Paginator is iterator of paginated API, Param is the query param of cursor or offset,
+// CursorPath is the xjson path of next cursor, ItemsPath is the xjson path of items in page,
+// empty ItemsPath is the whole page, the iteration stops after MaxPages pages, 0 is not limited
+type Paginator struct {
+	Request    *Request
+	URL        string
+	Args       []interface{}
+	Mode       int
+	Param      string
+	CursorPath string
+	ItemsPath  string
+	MaxPages   int
+	Pages      int
+	next       string
+	offset     int
+	done       bool
+}
+
+// NewPaginator returns a new paginator following the rel="next" link of Link header, args are passed to Do
+func (r *Request) NewPaginator(surl string, args ...interface{}) *Paginator {
+	return &Paginator{
+		Request: r,
+		URL:     surl,
+		Args:    args,
+		Mode:    PageLink,
+	}
+}
+
+// NewCursorPaginator returns a new paginator sending the cursor at cursorPath of page as query param,
+// the iteration stops when the cursor is empty or not changed
+func (r *Request) NewCursorPaginator(surl, cursorPath, param string, args ...interface{}) *Paginator {
+	return &Paginator{
+		Request:    r,
+		URL:        surl,
+		Args:       args,
+		Mode:       PageCursor,
+		Param:      param,
+		CursorPath: cursorPath,
+	}
+}
+
+// NewOffsetPaginator returns a new paginator sending the number of items at itemsPath received as query param,
+// the offset starts from the param in surl or args, the iteration stops when a page has no item
+func (r *Request) NewOffsetPaginator(surl, itemsPath, param string, args ...interface{}) *Paginator {
+	return &Paginator{
+		Request:   r,
+		URL:       surl,
+		Args:      args,
+		Mode:      PageOffset,
+		Param:     param,
+		ItemsPath: itemsPath,
+	}
+}
+
+// Next returns response of the next page, io.EOF is returned if there is no more page,
+// the response body of cursor and offset mode is read into memory for finding the next page
+func (p *Paginator) Next(ctx context.Context) (*Response, error) {
+	if p.done || (p.MaxPages > 0 && p.Pages >= p.MaxPages) {
+		return nil, io.EOF
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	surl := p.URL
+	args := p.Args
+	if p.Pages == 0 && p.Mode == PageOffset {
+		p.offset, _ = strconv.Atoi(paramValue(p.URL, p.Args, p.Param))
+	}
+
+	if p.Pages > 0 {
+		switch p.Mode {
+		case PageLink:
+			surl = p.next
+			args = withoutQuery(p.Args)
+		case PageCursor:
+			surl = urlWithoutParam(p.URL, p.Param)
+			args = append(withoutParam(p.Args, p.Param), QueryParam{p.Param: p.next})
+		case PageOffset:
+			surl = urlWithoutParam(p.URL, p.Param)
+			args = append(withoutParam(p.Args, p.Param), QueryParam{p.Param: p.offset})
+		}
+	}
+
+	rsp, err := p.Request.Get(ctx, surl, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		rsp.Close()
+		return nil, fmt.Errorf("xhttp: page returns %s", rsp.Response.Status)
+	}
+
+	p.Pages++
+
+	switch p.Mode {
+	case PageLink:
+		base := rsp.URL
+		if rsp.Response.Request != nil {
+			base = rsp.Response.Request.URL
+		}
+		p.next = linkNext(rsp.Response.Header["Link"], base)
+		p.done = p.next == ""
+	case PageCursor:
+		j, err := rsp.pageJSON()
+		if err != nil {
+			return nil, err
+		}
+		cursor := jsonText(j.Get(p.CursorPath))
+		p.done = cursor == "" || cursor == p.next
+		p.next = cursor
+	case PageOffset:
+		items, err := p.items(rsp)
+		if err != nil {
+			return nil, err
+		}
+		p.done = len(items) == 0
+		p.offset += len(items)
+	}
+
+	return rsp, nil
+}
+
+// NextItems returns items at ItemsPath of the next page, io.EOF is returned if there is no more page
+func (p *Paginator) NextItems(ctx context.Context) ([]*xjson.Json, error) {
+	rsp, err := p.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.items(rsp)
+}
+
+// Each call fn for every item of all pages until no more page or fn returns error
+func (p *Paginator) Each(ctx context.Context, fn func(*xjson.Json) error) error {
+	for {
+		items, err := p.NextItems(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, v := range items {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// items returns items at ItemsPath of page
+func (p *Paginator) items(rsp *Response) ([]*xjson.Json, error) {
+	j, err := rsp.pageJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	j = j.Get(p.ItemsPath)
+	if !j.IsArray() {
+		return nil, fmt.Errorf("xhttp: items at %q is not array", p.ItemsPath)
+	}
+
+	items := make([]*xjson.Json, j.Len())
+	for i := range items {
+		items[i] = j.Index(i)
+	}
+
+	return items, nil
+}
+
+// pageJSON returns response body as json, the body is kept for reading again
+func (r *Response) pageJSON() (*xjson.Json, error) {
+	b, err := r.buffer()
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := xjson.Loads(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("xhttp: decode page failed: %s", err.Error())
+	}
+
+	return j, nil
+}
+
+// withoutQuery returns args without query params, they are included in the next link
+func withoutQuery(args []interface{}) []interface{} {
+	result := []interface{}{}
+	for _, v := range args {
+		switch v.(type) {
+		case QueryParam, url.Values:
+			continue
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// withoutParam returns args without the query param of name, it is replaced by the cursor or offset
+func withoutParam(args []interface{}, name string) []interface{} {
+	result := []interface{}{}
+	for _, v := range args {
+		switch vv := v.(type) {
+		case QueryParam:
+			if _, ok := vv[name]; ok {
+				q := QueryParam{}
+				for k, x := range vv {
+					if k != name {
+						q[k] = x
+					}
+				}
+				v = q
+			}
+		case url.Values:
+			if _, ok := vv[name]; ok {
+				q := url.Values{}
+				for k, x := range vv {
+					if k != name {
+						q[k] = x
+					}
+				}
+				v = q
+			}
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// paramValue returns value of query param of name in args or url, the args are preferred
+func paramValue(surl string, args []interface{}, name string) string {
+	for i := len(args) - 1; i >= 0; i-- {
+		switch vv := args[i].(type) {
+		case QueryParam:
+			if v, ok := vv[name]; ok {
+				return fmt.Sprint(v)
+			}
+		case url.Values:
+			if _, ok := vv[name]; ok {
+				return vv.Get(name)
+			}
+		}
+	}
+
+	u, err := url.Parse(surl)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get(name)
+}
+
+// urlWithoutParam returns url without the query param of name, it is replaced by the cursor or offset
+func urlWithoutParam(surl, name string) string {
+	u, err := url.Parse(surl)
+	if err != nil {
+		return surl
+	}
+
+	query := u.Query()
+	if _, ok := query[name]; !ok {
+		return surl
+	}
+
+	query.Del(name)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// jsonText returns json string or number as text, empty if it is other type
+func jsonText(j *xjson.Json) string {
+	if v, err := j.String(); err == nil {
+		return v
+	}
+
+	if v, err := j.Int64(); err == nil {
+		return strconv.FormatInt(v, 10)
+	}
+
+	return ""
+}
+
+// linkNext returns the url of rel="next" link in Link headers, relative url is resolved by base,
+// the base is the final url after redirects
+func linkNext(links []string, base *url.URL) string {
+	for _, v := range links {
+		for {
+			v = strings.TrimLeft(v, " \t,")
+			if !strings.HasPrefix(v, "<") {
+				break
+			}
+
+			end := strings.Index(v, ">")
+			if end < 0 {
+				break
+			}
+
+			target := v[1:end]
+			v = v[end+1:]
+
+			var rel string
+			rel, v = linkRel(v)
+			for _, r := range strings.Fields(rel) {
+				if strings.EqualFold(r, "next") {
+					u, err := base.Parse(target)
+					if err != nil {
+						return ""
+					}
+					return u.String()
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// linkRel returns the rel param of link and the rest of links
+func linkRel(s string) (string, string) {
+	rel := ""
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ";") {
+			return rel, s
+		}
+
+		s = strings.TrimLeft(s[1:], " \t")
+		i := strings.IndexAny(s, "=;,")
+		if i < 0 {
+			return rel, ""
+		}
+
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		if s[i] != '=' {
+			s = s[i:]
+			continue
+		}
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		value := ""
+		if strings.HasPrefix(s, `"`) {
+			j := 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			value = strings.Replace(s[1:minInt(j, len(s))], `\`, "", -1)
+			s = s[minInt(j+1, len(s)):]
+		} else {
+			j := strings.IndexAny(s, ";,")
+			if j < 0 {
+				j = len(s)
+			}
+			value = strings.TrimSpace(s[:j])
+			s = s[j:]
+		}
+
+		if name == "rel" && rel == "" {
+			rel = value
+		}
+	}
+}
+
+// minInt returns the smaller int
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
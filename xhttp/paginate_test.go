@@ -0,0 +1,270 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xjson"
+)
+
+func TestLinkNext(t *testing.T) {
+	base, _ := url.Parse("https://www.likexian.com/api/items?page=1")
+
+	tests := []struct {
+		in  []string
+		out string
+	}{
+		{nil, ""},
+		{[]string{`<https://www.likexian.com/api/items?page=2>; rel="next"`}, "https://www.likexian.com/api/items?page=2"},
+		{[]string{`</api/items?page=2>; rel=next`}, "https://www.likexian.com/api/items?page=2"},
+		{[]string{`<items?page=2>;rel="next last"`}, "https://www.likexian.com/api/items?page=2"},
+		{[]string{`<?page=9>; rel="last", <?page=2>; title="a, b;c"; rel="next"`}, "https://www.likexian.com/api/items?page=2"},
+		{[]string{`<?page=9>; rel="last"`, `<?page=2>; REL="Next"`}, "https://www.likexian.com/api/items?page=2"},
+		{[]string{`<?page=1>; rel="prev"; type="text/html", <?page=9>; rel="last"`}, ""},
+		{[]string{`<?page=2`}, ""},
+		{[]string{`?page=2; rel="next"`}, ""},
+		{[]string{`<?page=2>; rel`}, ""},
+	}
+
+	for _, v := range tests {
+		assert.Equal(t, linkNext(v.in, base), v.out, v.in)
+	}
+}
+
+func TestPaginator(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if len(q["per_page"]) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		page, _ := strconv.Atoi(q.Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		if page < 3 {
+			w.Header().Set("Link", fmt.Sprintf(`</items?page=%d&per_page=2>; rel="next", </items?page=3&per_page=2>; rel="last"`, page+1))
+		}
+		fmt.Fprintf(w, `[{"id": %d}, {"id": %d}]`, page*2-1, page*2)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	req := New()
+
+	ids := []int{}
+	p := req.NewPaginator(ts.URL+"/items", QueryParam{"per_page": 2})
+	err := p.Each(ctx, func(j *xjson.Json) error {
+		ids = append(ids, j.Get("id").MustInt())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ids, []int{1, 2, 3, 4, 5, 6})
+	assert.Equal(t, p.Pages, 3)
+
+	_, err = p.Next(ctx)
+	assert.Equal(t, err, io.EOF)
+
+	p = req.NewPaginator(ts.URL+"/items", QueryParam{"per_page": 2})
+	p.MaxPages = 2
+	for i := 0; i < 2; i++ {
+		rsp, err := p.Next(ctx)
+		assert.Nil(t, err)
+		text, err := rsp.String()
+		assert.Nil(t, err)
+		assert.Contains(t, text, fmt.Sprintf(`{"id": %d}`, i*2+1))
+	}
+	_, err = p.Next(ctx)
+	assert.Equal(t, err, io.EOF)
+
+	p = req.NewPaginator(ts.URL + "/items")
+	_, err = p.Next(ctx)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "400")
+
+	p = req.NewPaginator(ts.URL+"/items", QueryParam{"per_page": 2})
+	err = p.Each(ctx, func(j *xjson.Json) error {
+		return fmt.Errorf("stop")
+	})
+	assert.Equal(t, err.Error(), "stop")
+
+	rs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/items" && r.URL.RawQuery == "" {
+			http.Redirect(w, r, "/v2/items", http.StatusFound)
+			return
+		}
+		if r.URL.Path != "/v2/items" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 2 {
+			w.Header().Set("Link", fmt.Sprintf(`<items?page=%d>; rel="next"`, page+1))
+		}
+		fmt.Fprintf(w, `[{"id": %d}]`, page)
+	}))
+	defer rs.Close()
+
+	ids = []int{}
+	p = req.NewPaginator(rs.URL + "/v1/items")
+	err = p.Each(ctx, func(j *xjson.Json) error {
+		ids = append(ids, j.Get("id").MustInt())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ids, []int{0, 1, 2})
+
+	cctx, cancel := context.WithCancel(ctx)
+	p = req.NewPaginator(ts.URL+"/items", QueryParam{"per_page": 2})
+	_, err = p.Next(cctx)
+	assert.Nil(t, err)
+	cancel()
+	_, err = p.Next(cctx)
+	assert.Equal(t, err, context.Canceled)
+}
+
+func TestCursorPaginator(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			w.Write([]byte(`{"data": [1, 2], "meta": {"next": "abc"}}`))
+		case "abc":
+			w.Write([]byte(`{"data": [3], "meta": {"next": 100}}`))
+		case "100":
+			w.Write([]byte(`{"data": [4], "meta": {"next": null}}`))
+		default:
+			w.Write([]byte(`invalid`))
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	p := New().NewCursorPaginator(ts.URL, "meta.next", "cursor")
+	p.ItemsPath = "data"
+
+	values := []int{}
+	for {
+		items, err := p.NextItems(ctx)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		for _, v := range items {
+			values = append(values, v.MustInt())
+		}
+	}
+	assert.Equal(t, values, []int{1, 2, 3, 4})
+	assert.Equal(t, p.Pages, 3)
+
+	p = New().NewCursorPaginator(ts.URL, "meta.next", "cursor")
+	rsp, err := p.Next(ctx)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Contains(t, text, `"next": "abc"`)
+	_, err = p.NextItems(ctx)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not array")
+
+	// the cursor in url is replaced by the next one
+	values = []int{}
+	p = New().NewCursorPaginator(ts.URL+"?cursor=abc", "meta.next", "cursor")
+	p.ItemsPath = "data"
+	p.MaxPages = 5
+	err = p.Each(ctx, func(j *xjson.Json) error {
+		values = append(values, j.MustInt())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, values, []int{3, 4})
+
+	p = New().NewCursorPaginator(ts.URL, "meta.next", "cursor", QueryParam{"cursor": "bad"})
+	_, err = p.Next(ctx)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "decode page failed")
+}
+
+func TestOffsetPaginator(t *testing.T) {
+	data := []string{"a", "b", "c", "d", "e"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		end := offset + 2
+		if end > len(data) {
+			end = len(data)
+		}
+		if offset > end {
+			offset = end
+		}
+		items := []string{}
+		for _, v := range data[offset:end] {
+			items = append(items, `"`+v+`"`)
+		}
+		fmt.Fprintf(w, `{"result": {"items": [%s]}}`, strings.Join(items, ","))
+	}))
+	defer ts.Close()
+
+	values := []string{}
+	p := New().NewOffsetPaginator(ts.URL, "result.items", "offset", QueryParam{"limit": 2})
+	err := p.Each(context.Background(), func(j *xjson.Json) error {
+		values = append(values, j.MustString())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, values, data)
+	assert.Equal(t, p.Pages, 4)
+
+	// the offset in url or args is the start, it is replaced by the next one
+	tests := []struct {
+		surl string
+		args []interface{}
+		out  []string
+	}{
+		{ts.URL + "?offset=0", []interface{}{QueryParam{"limit": 2}}, data},
+		{ts.URL + "?offset=2&limit=2", nil, data[2:]},
+		{ts.URL, []interface{}{QueryParam{"limit": 2, "offset": 1}}, data[1:]},
+		{ts.URL, []interface{}{url.Values{"offset": []string{"3"}}}, data[3:]},
+	}
+
+	for _, v := range tests {
+		values = []string{}
+		p = New().NewOffsetPaginator(v.surl, "result.items", "offset", v.args...)
+		p.MaxPages = 5
+		err = p.Each(context.Background(), func(j *xjson.Json) error {
+			values = append(values, j.MustString())
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, values, v.out, v.surl)
+	}
+
+	p = New().NewOffsetPaginator(ts.URL, "result", "offset")
+	_, err = p.Next(context.Background())
+	assert.NotNil(t, err)
+}
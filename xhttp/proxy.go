@@ -0,0 +1,540 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likexian/gokit/xrand"
+)
+
+// Proxy pool strategies
+const (
+	// ProxyRoundRobin is using proxies in turn
+	ProxyRoundRobin = iota
+	// ProxyRandom is using proxy randomly
+	ProxyRandom
+)
+
+// SOCKS5 protocol values
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+	socks5Connect      = 0x01
+	socks5AddrIPv4     = 0x01
+	socks5AddrDomain   = 0x03
+	socks5AddrIPv6     = 0x04
+)
+
+// socks5Replies is message of SOCKS5 reply code
+var socks5Replies = map[byte]string{
+	0x01: "general server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "ttl expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// Socks5Dialer is SOCKS5 proxy dialer, username and password auth is used if Username is not empty,
+// the target host is resolved by proxy, Forward is used for connecting to proxy,
+// connecting and handshake are limited by Timeout if it is not zero
+type Socks5Dialer struct {
+	Addr     string
+	Username string
+	Password string
+	Timeout  time.Duration
+	Forward  func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// ProxyPool is pool of proxies selected for every request, the proxy is removed for CoolDown
+// after MaxFails consecutive failures, only failure of connecting or handshake to the proxy
+// and 407 status are failures, errors of target and canceled requests are not counted
+type ProxyPool struct {
+	Strategy int
+	MaxFails int
+	CoolDown time.Duration
+	mutex    sync.Mutex
+	proxies  []*poolProxy
+	next     int
+}
+
+// poolProxy is proxy in pool
+type poolProxy struct {
+	url       *url.URL
+	fails     int
+	downUntil time.Time
+}
+
+// proxyContextKey is context key of selected proxy
+type proxyContextKey struct{}
+
+// proxyTrace records connecting to proxy of request
+type proxyTrace struct {
+	mutex      sync.Mutex
+	connected  bool
+	connectErr bool
+	tlsErr     bool
+	gotConn    bool
+}
+
+// NewSocks5Dialer returns a new SOCKS5 proxy dialer
+func NewSocks5Dialer(addr, username, password string) *Socks5Dialer {
+	return &Socks5Dialer{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+	}
+}
+
+// NewProxyPool returns a new proxy pool, proxy without scheme is http proxy,
+// the proxy is removed for 60 seconds after failure by default
+func NewProxyPool(strategy int, proxies ...string) (*ProxyPool, error) {
+	p := &ProxyPool{
+		Strategy: strategy,
+		MaxFails: 1,
+		CoolDown: 60 * time.Second,
+	}
+
+	err := p.Add(proxies...)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// SetSocks5Proxy set SOCKS5 proxy of request, the dialer of transport is connected via the proxy,
+// it is kept when setting timeout
+func (r *Request) SetSocks5Proxy(addr, username, password string) *Request {
+	r.Socks5 = NewSocks5Dialer(addr, username, password)
+	r.Client.Transport.(*http.Transport).Proxy = nil
+	r.setDialer()
+
+	return r
+}
+
+// SetProxyPool set proxy pool of request, the proxy is selected for every sending
+func (r *Request) SetProxyPool(pool *ProxyPool) *Request {
+	r.ProxyPool = pool
+	return r.SetProxy(proxyFromContext)
+}
+
+// Dial connects to addr via SOCKS5 proxy
+func (d *Socks5Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr via SOCKS5 proxy with context
+func (d *Socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("xhttp: socks5 not supported network: %s", network)
+	}
+
+	forward := d.Forward
+	if forward == nil {
+		forward = (&net.Dialer{}).DialContext
+	}
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	conn, err := forward(ctx, "tcp", d.Addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("xhttp: socks5 connect proxy failed: %s", err.Error())
+	}
+
+	if hasDeadline {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err = d.handshake(conn, addr)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if hasDeadline && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// handshake do SOCKS5 negotiation, auth and connect
+func (d *Socks5Dialer) handshake(conn net.Conn, addr string) error {
+	host, sport, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("xhttp: socks5 invalid address: %s", addr)
+	}
+
+	port, err := strconv.Atoi(sport)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("xhttp: socks5 invalid port: %s", sport)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if d.Username != "" {
+		methods = append(methods, socks5AuthPassword)
+	}
+
+	b := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(b); err != nil {
+		return fmt.Errorf("xhttp: socks5 write greeting failed: %s", err.Error())
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("xhttp: socks5 read greeting failed: %s", err.Error())
+	}
+
+	if reply[0] != socks5Version {
+		return fmt.Errorf("xhttp: socks5 unexpected version: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if d.Username == "" {
+			return fmt.Errorf("xhttp: socks5 proxy requires auth")
+		}
+		if len(d.Username) > 255 || len(d.Password) > 255 {
+			return fmt.Errorf("xhttp: socks5 username or password too long")
+		}
+		b = []byte{0x01, byte(len(d.Username))}
+		b = append(b, d.Username...)
+		b = append(b, byte(len(d.Password)))
+		b = append(b, d.Password...)
+		if _, err := conn.Write(b); err != nil {
+			return fmt.Errorf("xhttp: socks5 write auth failed: %s", err.Error())
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("xhttp: socks5 read auth failed: %s", err.Error())
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("xhttp: socks5 auth failed")
+		}
+	case socks5AuthNoAccept:
+		return fmt.Errorf("xhttp: socks5 no acceptable auth method")
+	default:
+		return fmt.Errorf("xhttp: socks5 unexpected auth method: %d", reply[1])
+	}
+
+	b = []byte{socks5Version, socks5Connect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5AddrIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5AddrIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("xhttp: socks5 host too long: %s", host)
+		}
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(b); err != nil {
+		return fmt.Errorf("xhttp: socks5 write connect failed: %s", err.Error())
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return fmt.Errorf("xhttp: socks5 read connect failed: %s", err.Error())
+	}
+
+	if head[1] != 0x00 {
+		msg, ok := socks5Replies[head[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown error %d", head[1])
+		}
+		return fmt.Errorf("xhttp: socks5 connect failed: %s", msg)
+	}
+
+	size := 0
+	switch head[3] {
+	case socks5AddrIPv4:
+		size = net.IPv4len
+	case socks5AddrIPv6:
+		size = net.IPv6len
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return fmt.Errorf("xhttp: socks5 read connect failed: %s", err.Error())
+		}
+		size = int(n[0])
+	default:
+		return fmt.Errorf("xhttp: socks5 unexpected address type: %d", head[3])
+	}
+
+	bound := make([]byte, size+2)
+	if _, err := io.ReadFull(conn, bound); err != nil {
+		return fmt.Errorf("xhttp: socks5 read connect failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Add add proxies to pool, proxy without scheme is http proxy
+func (p *ProxyPool) Add(proxies ...string) error {
+	ps := []*poolProxy{}
+	for _, v := range proxies {
+		if !strings.Contains(v, "://") {
+			v = "http://" + v
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("xhttp: invalid proxy url: %s", v)
+		}
+		ps = append(ps, &poolProxy{url: u})
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.proxies = append(p.proxies, ps...)
+
+	return nil
+}
+
+// Available returns proxies not in cool-down
+func (p *ProxyPool) Available() []*url.URL {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	us := []*url.URL{}
+	for _, v := range p.proxies {
+		if !now.Before(v.downUntil) {
+			us = append(us, v.url)
+		}
+	}
+
+	return us
+}
+
+// pick returns the next available proxy
+func (p *ProxyPool) pick() (*poolProxy, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	available := []int{}
+	for i, v := range p.proxies {
+		if !now.Before(v.downUntil) {
+			available = append(available, i)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, fmt.Errorf("xhttp: no available proxy in pool")
+	}
+
+	if p.Strategy == ProxyRandom {
+		return p.proxies[available[xrand.Int(len(available))]], nil
+	}
+
+	for _, i := range available {
+		if i >= p.next {
+			p.next = i + 1
+			return p.proxies[i], nil
+		}
+	}
+
+	p.next = available[0] + 1
+
+	return p.proxies[available[0]], nil
+}
+
+// report update the proxy state by result of request
+func (p *ProxyPool) report(v *poolProxy, failed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !failed {
+		v.fails = 0
+		return
+	}
+
+	v.fails++
+	if v.fails >= p.MaxFails {
+		v.fails = 0
+		v.downUntil = time.Now().Add(p.CoolDown)
+	}
+}
+
+// proxy returns sender using proxy selected from pool
+func (r *Request) proxy(send Sender) Sender {
+	if r.ProxyPool == nil {
+		return send
+	}
+
+	pool := r.ProxyPool
+	return func(req *http.Request) (*http.Response, error) {
+		v, err := pool.pick()
+		if err != nil {
+			return nil, err
+		}
+
+		ctx := req.Context()
+		trace := &proxyTrace{}
+		pctx := httptrace.WithClientTrace(context.WithValue(ctx, proxyContextKey{}, v.url), trace.clientTrace())
+		rsp, err := send(req.WithContext(pctx))
+		if ctx.Err() == nil {
+			pool.report(v, trace.failed(rsp, err))
+		}
+
+		return rsp, err
+	}
+}
+
+// proxyFromContext returns proxy selected from pool in request context
+func proxyFromContext(req *http.Request) (*url.URL, error) {
+	if u, ok := req.Context().Value(proxyContextKey{}).(*url.URL); ok {
+		return u, nil
+	}
+
+	return nil, nil
+}
+
+// clientTrace returns trace hooks recording connecting to proxy
+func (t *proxyTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		ConnectDone: func(network, addr string, err error) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if err != nil {
+				t.connectErr = true
+			} else {
+				t.connected = true
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			if err != nil {
+				t.tlsErr = true
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			t.gotConn = true
+		},
+	}
+}
+
+// failed returns whether the proxy is failed, it is failed if connecting to proxy failed,
+// or proxy handshake failed without reply, or proxy auth is required
+func (t *proxyTrace) failed(rsp *http.Response, err error) bool {
+	if err == nil {
+		return rsp.StatusCode == http.StatusProxyAuthRequired
+	}
+
+	msg := proxyError(err).Error()
+	if msg == http.StatusText(http.StatusProxyAuthRequired) {
+		return true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.connected {
+		return t.connectErr
+	}
+
+	if t.gotConn || t.tlsErr {
+		return false
+	}
+
+	return !proxyReplied(msg)
+}
+
+// proxyError returns the underlying error of transport error
+func proxyError(err error) error {
+	for {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			if e.Op != "proxyconnect" {
+				return err
+			}
+			err = e.Err
+		default:
+			return err
+		}
+	}
+}
+
+// proxyReplied returns whether the message is reply of proxy about target,
+// it is status text of HTTP CONNECT or reply of SOCKS5 connect
+func proxyReplied(msg string) bool {
+	if strings.Contains(msg, "socks connect") && strings.Contains(msg, "unknown error ") {
+		return true
+	}
+
+	for i := 100; i < 600; i++ {
+		if text := http.StatusText(i); text != "" && text == msg {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,435 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// socks5Server is SOCKS5 server for testing
+type socks5Server struct {
+	listener net.Listener
+	username string
+	password string
+}
+
+func newSocks5Server(t *testing.T, username, password string) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	s := &socks5Server{listener: l, username: username, password: password}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	if s.username == "" {
+		conn.Write([]byte{socks5Version, socks5AuthNone})
+	} else {
+		if !strings.Contains(string(methods), string([]byte{socks5AuthPassword})) {
+			conn.Write([]byte{socks5Version, socks5AuthNoAccept})
+			return
+		}
+		conn.Write([]byte{socks5Version, socks5AuthPassword})
+		b := make([]byte, 2)
+		io.ReadFull(conn, b)
+		username := make([]byte, b[1])
+		io.ReadFull(conn, username)
+		io.ReadFull(conn, b[:1])
+		password := make([]byte, b[0])
+		io.ReadFull(conn, password)
+		if string(username) != s.username || string(password) != s.password {
+			conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+
+	host := ""
+	switch req[3] {
+	case socks5AddrIPv4:
+		b := make([]byte, net.IPv4len)
+		io.ReadFull(conn, b)
+		host = net.IP(b).String()
+	case socks5AddrIPv6:
+		b := make([]byte, net.IPv6len)
+		io.ReadFull(conn, b)
+		host = net.IP(b).String()
+	case socks5AddrDomain:
+		b := make([]byte, 1)
+		io.ReadFull(conn, b)
+		name := make([]byte, b[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port))))
+	if err != nil {
+		conn.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+
+	conn.Write([]byte{socks5Version, 0x00, 0x00, socks5AddrDomain, 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// newConnectProxy returns HTTP proxy supporting CONNECT for testing
+func newConnectProxy() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+		conn.Close()
+		target.Close()
+	}))
+}
+
+// newSilentServer returns listener accepting connection without any reply
+func newSilentServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestSocks5Dialer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("likexian"))
+	}))
+	defer ts.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	ctx := context.Background()
+
+	s := newSocks5Server(t, "", "")
+	defer s.listener.Close()
+
+	for _, v := range []string{"localhost", "127.0.0.1"} {
+		req := New().SetSocks5Proxy(s.listener.Addr().String(), "", "")
+		rsp, err := req.Get(ctx, "http://"+net.JoinHostPort(v, port))
+		assert.Nil(t, err)
+		text, err := rsp.String()
+		assert.Nil(t, err)
+		assert.Equal(t, text, "likexian")
+	}
+
+	a := newSocks5Server(t, "likexian", "secret")
+	defer a.listener.Close()
+
+	req := New().SetConnectTimeout(3).SetSocks5Proxy(a.listener.Addr().String(), "likexian", "secret")
+	rsp, err := req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+
+	// the proxy is kept after setting timeout, traffic never goes direct
+	req = New().SetSocks5Proxy("127.0.0.1:1", "", "")
+	for _, v := range []*Request{req.SetTimeout(req.GetTimeout()), req.SetConnectTimeout(3),
+		req.SetKeepAliveTimeout(0), req.Clone().SetConnectTimeout(5)} {
+		_, err = v.Get(ctx, ts.URL)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "socks5 connect proxy failed")
+	}
+
+	origin := New().SetSocks5Proxy(s.listener.Addr().String(), "", "")
+	clone := origin.Clone().SetConnectTimeout(3)
+	assert.Equal(t, clone.Socks5.Addr, origin.Socks5.Addr)
+	clone.SetSocks5Proxy("127.0.0.1:1", "", "")
+	_, err = clone.Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	rsp, err = origin.SetConnectTimeout(3).Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	rsp.Close()
+
+	d := NewSocks5Dialer(a.listener.Addr().String(), "likexian", "bad")
+	_, err = d.Dial("tcp", ts.Listener.Addr().String())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "auth failed")
+
+	d = NewSocks5Dialer(a.listener.Addr().String(), "", "")
+	_, err = d.Dial("tcp", ts.Listener.Addr().String())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no acceptable auth")
+
+	d = NewSocks5Dialer(s.listener.Addr().String(), "", "")
+	_, err = d.Dial("tcp", "127.0.0.1:1")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+
+	_, err = d.Dial("udp", "127.0.0.1:1")
+	assert.NotNil(t, err)
+
+	_, err = d.Dial("tcp", "127.0.0.1")
+	assert.NotNil(t, err)
+
+	_, err = d.Dial("tcp", "127.0.0.1:0")
+	assert.NotNil(t, err)
+
+	d = NewSocks5Dialer("127.0.0.1:1", "", "")
+	_, err = d.Dial("tcp", ts.Listener.Addr().String())
+	assert.NotNil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			time.Sleep(time.Second)
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d = NewSocks5Dialer(l.Addr().String(), "", "")
+	_, err = d.DialContext(ctx, "tcp", ts.Listener.Addr().String())
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	// the handshake is limited by connect timeout without context deadline
+	silent := newSilentServer(t)
+	defer silent.Close()
+	start := time.Now()
+	_, err = New().SetConnectTimeout(1).SetSocks5Proxy(silent.Addr().String(), "", "").
+		Get(context.Background(), ts.URL)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 3*time.Second)
+}
+
+func TestProxyPool(t *testing.T) {
+	proxy := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(name + " " + r.URL.String()))
+		}))
+	}
+
+	a := proxy("a", http.StatusOK)
+	defer a.Close()
+	b := proxy("b", http.StatusOK)
+	defer b.Close()
+	c := proxy("c", http.StatusProxyAuthRequired)
+	defer c.Close()
+
+	pool, err := NewProxyPool(ProxyRoundRobin, a.URL, strings.TrimPrefix(b.URL, "http://"), c.URL, "http://127.0.0.1:1")
+	assert.Nil(t, err)
+	assert.Equal(t, len(pool.Available()), 4)
+
+	ctx := context.Background()
+	req := New().SetProxyPool(pool)
+	results := []string{}
+	for i := 0; i < 6; i++ {
+		rsp, err := req.Get(ctx, "http://www.likexian.com/")
+		if err != nil {
+			results = append(results, "error")
+			continue
+		}
+		text, err := rsp.String()
+		assert.Nil(t, err)
+		results = append(results, text[:1])
+	}
+
+	assert.Equal(t, results, []string{"a", "b", "c", "error", "a", "b"})
+	assert.Equal(t, len(pool.Available()), 2)
+
+	pool.Strategy = ProxyRandom
+	for i := 0; i < 10; i++ {
+		rsp, err := req.Clone().Get(ctx, "http://www.likexian.com/")
+		assert.Nil(t, err)
+		rsp.Close()
+		assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	}
+
+	pool, err = NewProxyPool(ProxyRoundRobin, "http://127.0.0.1:1")
+	assert.Nil(t, err)
+	pool.MaxFails = 2
+	pool.CoolDown = 100 * time.Millisecond
+	req = New().SetProxyPool(pool)
+	for i := 0; i < 3; i++ {
+		_, err = req.Get(ctx, "http://www.likexian.com/")
+		assert.NotNil(t, err)
+	}
+	assert.Contains(t, err.Error(), "no available proxy")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, len(pool.Available()), 1)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = req.Get(cctx, "http://www.likexian.com/")
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pool.Available()), 1)
+
+	_, err = NewProxyPool(ProxyRoundRobin, "http://")
+	assert.NotNil(t, err)
+}
+
+func TestProxyPoolFailure(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("likexian"))
+	}))
+	defer ts.Close()
+
+	cp := newConnectProxy()
+	defer cp.Close()
+
+	pool, err := NewProxyPool(ProxyRoundRobin, cp.URL)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	req := New().SetProxyPool(pool).SetClientTimeout(3)
+	req.Client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	rsp, err := req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+
+	// unreachable target is not failure of proxy
+	for _, v := range []string{"https://127.0.0.1:1/", "http://127.0.0.1:1/"} {
+		rsp, err = req.Get(ctx, v)
+		if err == nil {
+			assert.Equal(t, rsp.StatusCode, http.StatusBadGateway)
+			rsp.Close()
+		}
+		assert.Equal(t, len(pool.Available()), 1)
+	}
+
+	// error of target tls is not failure of proxy
+	_, err = New().SetProxyPool(pool).Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pool.Available()), 1)
+
+	// canceled request is not failure of proxy
+	silent := newSilentServer(t)
+	defer silent.Close()
+	pool, err = NewProxyPool(ProxyRoundRobin, silent.Addr().String())
+	assert.Nil(t, err)
+	req = New().SetProxyPool(pool)
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = req.Get(tctx, ts.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pool.Available()), 1)
+
+	// waiting for response of target is not failure of proxy
+	req.SetClientTimeout(1)
+	_, err = req.Get(ctx, "http://www.likexian.com/")
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pool.Available()), 1)
+
+	// handshake to proxy without reply is failure of proxy
+	_, err = req.Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pool.Available()), 0)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		for {
+			conn, err := closed.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	defer closed.Close()
+
+	pool, err = NewProxyPool(ProxyRoundRobin, closed.Addr().String())
+	assert.Nil(t, err)
+	_, err = New().SetProxyPool(pool).Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pool.Available()), 0)
+}
@@ -0,0 +1,298 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Resolve status set to Attempt.ResolveStatus
+const (
+	ResolveOverride = "OVERRIDE"
+	ResolveHit      = "HIT"
+	ResolveMiss     = "MISS"
+	ResolveStale    = "STALE"
+)
+
+// defaultResolverTTL is default ttl of resolver cache
+const defaultResolverTTL = 60 * time.Second
+
+// dialFunc is function for dialing connection
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Resolver is DNS resolver with cache, the addresses are cached for TTL, the expired addresses
+// are used if lookup failed and not expired over StaleTTL, 0 StaleTTL is not limited,
+// Lookup is net.DefaultResolver if not set, it is safe for sharing between requests
+type Resolver struct {
+	TTL      time.Duration
+	StaleTTL time.Duration
+	Lookup   func(ctx context.Context, host string) ([]string, error)
+	mutex    sync.Mutex
+	entries  map[string]*resolverEntry
+}
+
+// resolverEntry storing cached addresses of host
+type resolverEntry struct {
+	addrs   []string
+	expires time.Time
+	next    uint32
+	call    *resolverCall
+}
+
+// resolverCall is in-flight lookup shared by concurrent dialing
+type resolverCall struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+// resolvingContextKey is context key of resolving setting
+type resolvingContextKey struct{}
+
+// NewResolver returns a new DNS resolver with cache ttl, ttl is 60 seconds if not set
+func NewResolver(ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = defaultResolverTTL
+	}
+
+	return &Resolver{
+		TTL:     ttl,
+		entries: map[string]*resolverEntry{},
+	}
+}
+
+// SetResolver set DNS resolver of request, the hosts not in SetHostAddr are resolved by it
+func (r *Request) SetResolver(resolver *Resolver) *Request {
+	r.Resolving.Resolver = resolver
+	return r
+}
+
+// SetHostAddr set addresses of host, host is host or host:port, address is ip or ip:port,
+// the request to host connects to the addresses in turn without DNS lookup,
+// no address removes the setting, with HTTP proxy it is applied to the proxy host
+func (r *Request) SetHostAddr(host string, addrs ...string) *Request {
+	if r.Resolving.Hosts == nil {
+		r.Resolving.Hosts = map[string][]string{}
+	}
+
+	host = strings.ToLower(host)
+	if len(addrs) == 0 {
+		delete(r.Resolving.Hosts, host)
+	} else {
+		r.Resolving.Hosts[host] = append([]string{}, addrs...)
+	}
+
+	if t, ok := r.Client.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+
+	return r
+}
+
+// Clear clear the cached addresses
+func (r *Resolver) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = map[string]*resolverEntry{}
+}
+
+// resolve returns addresses of host and resolve status
+func (r *Resolver) resolve(ctx context.Context, host string) (*resolverEntry, []string, string, error) {
+	r.mutex.Lock()
+	if r.entries == nil {
+		r.entries = map[string]*resolverEntry{}
+	}
+
+	e, ok := r.entries[host]
+	if !ok {
+		e = &resolverEntry{}
+		r.entries[host] = e
+	}
+
+	if len(e.addrs) > 0 && time.Now().Before(e.expires) {
+		addrs := e.addrs
+		r.mutex.Unlock()
+		return e, addrs, ResolveHit, nil
+	}
+
+	call := e.call
+	if call == nil {
+		call = &resolverCall{done: make(chan struct{})}
+		e.call = call
+		r.mutex.Unlock()
+
+		call.addrs, call.err = r.lookup(ctx, host)
+
+		r.mutex.Lock()
+		e.call = nil
+		if call.err == nil {
+			e.addrs = call.addrs
+			e.expires = time.Now().Add(r.TTL)
+		}
+		close(call.done)
+		r.mutex.Unlock()
+	} else {
+		r.mutex.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, nil, "", ctx.Err()
+		}
+	}
+
+	if call.err == nil {
+		return e, call.addrs, ResolveMiss, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(e.addrs) > 0 && (r.StaleTTL <= 0 || time.Now().Before(e.expires.Add(r.StaleTTL))) {
+		return e, e.addrs, ResolveStale, nil
+	}
+
+	return nil, nil, "", call.err
+}
+
+// lookup returns addresses of host by DNS lookup
+func (r *Resolver) lookup(ctx context.Context, host string) ([]string, error) {
+	var addrs []string
+	var err error
+
+	if r.Lookup != nil {
+		addrs, err = r.Lookup(ctx, host)
+	} else {
+		var ips []net.IPAddr
+		ips, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+		for _, v := range ips {
+			addrs = append(addrs, v.String())
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("xhttp: no address of host %s", host)
+	}
+
+	return addrs, nil
+}
+
+// resolve returns addresses for dialing addr in turn and resolve status,
+// nil addresses is returned if addr is not resolved by setting
+func (r *Resolving) resolve(ctx context.Context, addr string) ([]string, string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", nil
+	}
+
+	host = strings.ToLower(host)
+	addrs, ok := r.Hosts[net.JoinHostPort(host, port)]
+	if !ok {
+		addrs, ok = r.Hosts[host]
+	}
+
+	if ok && len(addrs) > 0 {
+		return rotateAddrs(addrs, port, &r.next), ResolveOverride, nil
+	}
+
+	if r.Resolver == nil || net.ParseIP(host) != nil {
+		return nil, "", nil
+	}
+
+	e, addrs, status, err := r.Resolver.resolve(ctx, host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return rotateAddrs(addrs, port, &e.next), status, nil
+}
+
+// resolve set resolving setting to request context for dialing
+func (r *Request) resolve(send Sender) Sender {
+	if len(r.Resolving.Hosts) == 0 && r.Resolving.Resolver == nil {
+		return send
+	}
+
+	resolving := &r.Resolving
+	return func(req *http.Request) (*http.Response, error) {
+		return send(req.WithContext(context.WithValue(req.Context(), resolvingContextKey{}, resolving)))
+	}
+}
+
+// resolveDial returns dial function connecting to the addresses resolved by setting in context,
+// the addresses are tried in turn until connected
+func resolveDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		resolving, ok := ctx.Value(resolvingContextKey{}).(*Resolving)
+		if !ok {
+			return dial(ctx, network, addr)
+		}
+
+		addrs, status, err := resolving.resolve(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if addrs == nil {
+			return dial(ctx, network, addr)
+		}
+
+		if p, ok := ctx.Value(phaseContextKey{}).(*phaseTrace); ok {
+			p.setResolve(status)
+		}
+
+		var conn net.Conn
+		for _, v := range addrs {
+			conn, err = dial(ctx, network, v)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+
+		return conn, err
+	}
+}
+
+// rotateAddrs returns addresses with port started from the next one
+func rotateAddrs(addrs []string, port string, next *uint32) []string {
+	n := len(addrs)
+	start := int((atomic.AddUint32(next, 1) - 1) % uint32(n))
+
+	result := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		addr := addrs[(start+i)%n]
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+		}
+		result = append(result, addr)
+	}
+
+	return result
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestRotateAddrs(t *testing.T) {
+	var next uint32
+	addrs := []string{"10.0.0.1", "10.0.0.2:8080", "::1"}
+
+	assert.Equal(t, rotateAddrs(addrs, "80", &next), []string{"10.0.0.1:80", "10.0.0.2:8080", "[::1]:80"})
+	assert.Equal(t, rotateAddrs(addrs, "80", &next), []string{"10.0.0.2:8080", "[::1]:80", "10.0.0.1:80"})
+	assert.Equal(t, rotateAddrs(addrs, "80", &next), []string{"[::1]:80", "10.0.0.1:80", "10.0.0.2:8080"})
+	assert.Equal(t, rotateAddrs(addrs, "80", &next), []string{"10.0.0.1:80", "10.0.0.2:8080", "[::1]:80"})
+}
+
+func TestResolver(t *testing.T) {
+	var called int32
+	var failed int32
+	r := NewResolver(50 * time.Millisecond)
+	r.Lookup = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&called, 1)
+		time.Sleep(10 * time.Millisecond)
+		if atomic.LoadInt32(&failed) == 1 {
+			return nil, fmt.Errorf("lookup %s failed", host)
+		}
+		if host == "empty.likexian.com" {
+			return nil, nil
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, addrs, status, err := r.resolve(ctx, "www.likexian.com")
+			assert.Nil(t, err)
+			assert.Equal(t, addrs, []string{"127.0.0.1"})
+			assert.Equal(t, status, ResolveMiss)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, atomic.LoadInt32(&called), int32(1))
+
+	_, _, status, err := r.resolve(ctx, "www.likexian.com")
+	assert.Nil(t, err)
+	assert.Equal(t, status, ResolveHit)
+	assert.Equal(t, atomic.LoadInt32(&called), int32(1))
+
+	time.Sleep(50 * time.Millisecond)
+	atomic.StoreInt32(&failed, 1)
+	_, addrs, status, err := r.resolve(ctx, "www.likexian.com")
+	assert.Nil(t, err)
+	assert.Equal(t, addrs, []string{"127.0.0.1"})
+	assert.Equal(t, status, ResolveStale)
+	assert.Equal(t, atomic.LoadInt32(&called), int32(2))
+
+	r.StaleTTL = 10 * time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	_, _, _, err = r.resolve(ctx, "www.likexian.com")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed")
+
+	atomic.StoreInt32(&failed, 0)
+	_, _, _, err = r.resolve(ctx, "empty.likexian.com")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no address")
+
+	r.Clear()
+	_, _, status, err = r.resolve(ctx, "www.likexian.com")
+	assert.Nil(t, err)
+	assert.Equal(t, status, ResolveMiss)
+
+	r = &Resolver{TTL: time.Minute}
+	_, addrs, status, err = r.resolve(ctx, "localhost")
+	assert.Nil(t, err)
+	assert.True(t, len(addrs) > 0)
+	assert.Equal(t, status, ResolveMiss)
+}
+
+func TestHostAddr(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	ctx := context.Background()
+
+	req := New().SetKeepAliveTimeout(0).SetHostAddr("API.likexian.com", "127.0.0.1")
+	rsp, err := req.Get(ctx, "http://api.likexian.com:"+port+"/")
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "api.likexian.com:"+port)
+	assert.Equal(t, rsp.Tracing.Attempts[0].ResolveStatus, ResolveOverride)
+	assert.Equal(t, rsp.Tracing.Attempts[0].RemoteAddr, ts.Listener.Addr().String())
+
+	req.SetHostAddr("www.likexian.com:80", "127.0.0.1:1", ts.Listener.Addr().String())
+	for i := 0; i < 3; i++ {
+		rsp, err = req.Get(ctx, "http://www.likexian.com/")
+		assert.Nil(t, err)
+		text, err = rsp.String()
+		assert.Nil(t, err)
+		assert.Equal(t, text, "www.likexian.com")
+	}
+
+	clone := req.Clone().SetHostAddr("www.likexian.com:80")
+	assert.Equal(t, len(clone.Resolving.Hosts), 1)
+	assert.Equal(t, len(req.Resolving.Hosts), 2)
+
+	var called int32
+	resolver := NewResolver(time.Minute)
+	resolver.Lookup = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&called, 1)
+		return []string{"127.0.0.1"}, nil
+	}
+
+	req = New().SetKeepAliveTimeout(0).SetResolver(resolver).SetHostAddr("api.likexian.com", "127.0.0.1")
+	for _, v := range []string{ResolveMiss, ResolveHit, ResolveOverride} {
+		host := "www.likexian.com"
+		if v == ResolveOverride {
+			host = "api.likexian.com"
+		}
+		rsp, err = req.Get(ctx, "http://"+host+":"+port+"/")
+		assert.Nil(t, err)
+		rsp.Close()
+		assert.Equal(t, rsp.Tracing.Attempts[0].ResolveStatus, v)
+	}
+	assert.Equal(t, atomic.LoadInt32(&called), int32(1))
+
+	rsp, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.Tracing.Attempts[0].ResolveStatus, "")
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xrand"
+	"github.com/likexian/gokit/xtime"
+)
+
+// RetryPolicy decides whether a failed attempt shall be retried
+type RetryPolicy interface {
+	// Retry returns retry or not and the sleep duration before next attempt, attempt is start from 1
+	Retry(attempt int, rsp *http.Response, err error) (bool, time.Duration)
+}
+
+// Backoff is retry policy with exponential backoff and jitter
+type Backoff struct {
+	// StatusCodes is response status code to retry
+	StatusCodes []int
+	// RetryError returns the error shall be retried, nil to retry all errors
+	RetryError func(error) bool
+	// MinSleep is the sleep duration of first retry
+	MinSleep time.Duration
+	// MaxSleep is the max sleep duration of every retry
+	MaxSleep time.Duration
+	// Factor is the multiplier of sleep duration per retry
+	Factor float64
+	// Jitter is the random ratio of sleep duration, in [0, 1]
+	Jitter float64
+	// IgnoreRetryAfter set to ignore the Retry-After response header
+	IgnoreRetryAfter bool
+}
+
+// NewBackoff returns a new exponential backoff retry policy
+func NewBackoff() *Backoff {
+	return &Backoff{
+		StatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		MinSleep: 100 * time.Millisecond,
+		MaxSleep: 30 * time.Second,
+		Factor:   2,
+		Jitter:   0.2,
+	}
+}
+
+// Retry returns retry or not and the sleep duration before next attempt
+func (b *Backoff) Retry(attempt int, rsp *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		if b.RetryError != nil && !b.RetryError(err) {
+			return false, 0
+		}
+		return true, b.sleep(attempt)
+	}
+
+	if rsp == nil || !assert.IsContains(b.StatusCodes, rsp.StatusCode) {
+		return false, 0
+	}
+
+	if !b.IgnoreRetryAfter {
+		if d, ok := parseRetryAfter(rsp.Header.Get("Retry-After")); ok {
+			if b.MaxSleep > 0 && d > b.MaxSleep {
+				d = b.MaxSleep
+			}
+			return true, d
+		}
+	}
+
+	return true, b.sleep(attempt)
+}
+
+// sleep returns the backoff sleep duration of attempt
+func (b *Backoff) sleep(attempt int) time.Duration {
+	factor := b.Factor
+	if factor < 1 {
+		factor = 1
+	}
+
+	d := float64(b.MinSleep) * math.Pow(factor, float64(attempt-1))
+	if b.MaxSleep > 0 && d > float64(b.MaxSleep) {
+		d = float64(b.MaxSleep)
+	}
+
+	if b.Jitter > 0 {
+		jitter := math.Min(b.Jitter, 1)
+		d = d * (1 - jitter + 2*jitter*float64(xrand.Int(1000))/1000)
+		if b.MaxSleep > 0 && d > float64(b.MaxSleep) {
+			d = float64(b.MaxSleep)
+		}
+	}
+
+	return time.Duration(d)
+}
+
+// IsTimeoutError returns if error is network timeout
+func IsTimeoutError(err error) bool {
+	if e, ok := err.(net.Error); ok {
+		return e.Timeout()
+	}
+
+	return false
+}
+
+// parseRetryAfter returns the duration of Retry-After header, seconds or http date
+func parseRetryAfter(s string) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 {
+			return 0, false
+		}
+		return time.Duration(n) * time.Second, true
+	}
+
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return 0, false
+	}
+
+	d := time.Until(t)
+	if d < 0 {
+		d = 0
+	}
+
+	return d, true
+}
+
+// shouldRetry returns retry or not and the sleep duration before next attempt
+func (r *Request) shouldRetry(attempt int, rsp *http.Response, err error) (bool, time.Duration) {
+	if r.Retries.Times != -1 && attempt > r.Retries.Times {
+		return false, 0
+	}
+
+	if r.Retries.Policy != nil {
+		return r.Retries.Policy.Retry(attempt, rsp, err)
+	}
+
+	return err != nil, r.Retries.Sleep
+}
+
+// rewindBody reset request body for next attempt, returns false if body can not be replayed
+func rewindBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+
+	if req.GetBody == nil {
+		return false
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+
+	req.Body = body
+
+	return true
+}
+
+// discardBody read and close response body for reusing connection
+func discardBody(rsp *http.Response) {
+	if rsp == nil || rsp.Body == nil {
+		return
+	}
+
+	_, _ = io.CopyN(ioutil.Discard, rsp.Body, 4096)
+	rsp.Body.Close()
+}
+
+// sleepContext sleep for duration d, returns error if context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// send do http request with retries, the response is set to s
+func (r *Request) send(ctx context.Context, client *http.Client, req *http.Request, s *Response) (err error) {
+	var release func()
+	send := r.sender(client)
+	for attempt := 1; ; attempt++ {
+		s.Tracing.Retries += 1
+		generation := int64(0)
+		if r.Breaker != nil {
+			generation, err = r.Breaker.allow(req.URL.Host)
+			if err != nil {
+				s.Tracing.Attempts = append(s.Tracing.Attempts, Attempt{Error: err.Error()})
+				break
+			}
+		}
+
+		waitAt := xtime.Ms()
+		release, err = r.limitWait(ctx, req.URL.Host)
+		trace := Attempt{
+			WaitTime: xtime.Ms() - waitAt,
+		}
+		s.Tracing.WaitTime += trace.WaitTime
+		if err != nil {
+			if r.Breaker != nil {
+				r.Breaker.done(req.URL.Host, generation, nil, err, true)
+			}
+			trace.Error = err.Error()
+			s.Tracing.Attempts = append(s.Tracing.Attempts, trace)
+			break
+		}
+
+		sendAt := xtime.Ms()
+		traceReq, phase := withPhaseTrace(req)
+		s.Response, err = send(traceReq)
+		trace.SendTime = xtime.Ms() - sendAt
+		phase.fill(&trace)
+		if r.Breaker != nil {
+			r.Breaker.done(req.URL.Host, generation, s.Response, err, ctx.Err() != nil)
+		}
+		if err != nil {
+			release()
+			trace.Error = err.Error()
+		} else {
+			trace.StatusCode = s.Response.StatusCode
+			s.Response.Body = &limitBody{ReadCloser: s.Response.Body, release: release}
+		}
+
+		retry, sleep := false, time.Duration(0)
+		if ctx.Err() == nil && (req.Body == nil || req.GetBody != nil) {
+			retry, sleep = r.shouldRetry(attempt, s.Response, err)
+		}
+
+		if retry {
+			trace.SleepTime = int64(sleep / time.Millisecond)
+		}
+
+		s.Tracing.Attempts = append(s.Tracing.Attempts, trace)
+		if !retry {
+			break
+		}
+
+		if err == nil {
+			discardBody(s.Response)
+			s.Response = nil
+		}
+
+		err = sleepContext(ctx, sleep)
+		if err != nil {
+			break
+		}
+
+		if !rewindBody(req) {
+			err = fmt.Errorf("xhttp: rewind request body failed")
+			break
+		}
+	}
+
+	return
+}
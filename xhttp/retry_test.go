@@ -0,0 +1,180 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff()
+	b.Jitter = 0
+
+	retry, sleep := b.Retry(1, nil, fmt.Errorf("connect failed"))
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 100*time.Millisecond)
+
+	retry, sleep = b.Retry(3, nil, fmt.Errorf("connect failed"))
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 400*time.Millisecond)
+
+	retry, sleep = b.Retry(100, nil, fmt.Errorf("connect failed"))
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 30*time.Second)
+
+	retry, _ = b.Retry(1, &http.Response{StatusCode: 200, Header: http.Header{}}, nil)
+	assert.False(t, retry)
+
+	retry, sleep = b.Retry(1, &http.Response{StatusCode: 503, Header: http.Header{}}, nil)
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 100*time.Millisecond)
+
+	retry, sleep = b.Retry(1, &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": []string{"3"}}}, nil)
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 3*time.Second)
+
+	retry, sleep = b.Retry(1, &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": []string{"3600"}}}, nil)
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 30*time.Second)
+
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	retry, sleep = b.Retry(1, &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": []string{date}}}, nil)
+	assert.True(t, retry)
+	assert.Gt(t, sleep, 8*time.Second)
+	assert.Le(t, sleep, 10*time.Second)
+
+	b.IgnoreRetryAfter = true
+	retry, sleep = b.Retry(1, &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": []string{"3"}}}, nil)
+	assert.True(t, retry)
+	assert.Equal(t, sleep, 100*time.Millisecond)
+
+	b.RetryError = IsTimeoutError
+	retry, _ = b.Retry(1, nil, fmt.Errorf("connect failed"))
+	assert.False(t, retry)
+
+	b.Jitter = 0.5
+	b.RetryError = nil
+	for i := 0; i < 10; i++ {
+		_, sleep = b.Retry(2, nil, fmt.Errorf("connect failed"))
+		assert.Ge(t, sleep, 100*time.Millisecond)
+		assert.Le(t, sleep, 300*time.Millisecond)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	_, ok := parseRetryAfter("")
+	assert.False(t, ok)
+
+	_, ok = parseRetryAfter("-1")
+	assert.False(t, ok)
+
+	_, ok = parseRetryAfter("invalid")
+	assert.False(t, ok)
+
+	d, ok := parseRetryAfter("120")
+	assert.True(t, ok)
+	assert.Equal(t, d, 120*time.Second)
+
+	d, ok = parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.Equal(t, d, time.Duration(0))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&count, 1)%3 != 0 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, string(body))
+	}))
+	defer ts.Close()
+
+	req := New()
+	ctx := context.Background()
+
+	b := NewBackoff()
+	b.MinSleep = 10 * time.Millisecond
+
+	// no retry on status without policy
+	rsp, err := req.Do(ctx, "POST", ts.URL, "k=v")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 503)
+	assert.Equal(t, rsp.Tracing.Retries, 0)
+	assert.Equal(t, len(rsp.Tracing.Attempts), 1)
+
+	// retry on status, body is replayed
+	atomic.StoreInt32(&count, 0)
+	req.SetRetries(3, b)
+	rsp, err = req.Do(ctx, "POST", ts.URL, "k=v")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 200)
+	assert.Equal(t, rsp.Tracing.Retries, 2)
+	assert.Equal(t, len(rsp.Tracing.Attempts), 3)
+	assert.Equal(t, rsp.Tracing.Attempts[0].StatusCode, 503)
+	assert.Equal(t, rsp.Tracing.Attempts[2].StatusCode, 200)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "k=v")
+
+	// multipart body is replayed
+	atomic.StoreInt32(&count, 0)
+	rsp, err = req.Do(ctx, "POST", ts.URL, FormFile{"file": "../go.mod"})
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 200)
+	assert.Equal(t, rsp.Tracing.Retries, 2)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Contains(t, text, "module github.com/likexian/gokit")
+
+	// retry times exhausted
+	atomic.StoreInt32(&count, 0)
+	req.SetRetries(1)
+	rsp, err = req.Do(ctx, "GET", ts.URL)
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.StatusCode, 503)
+	assert.Equal(t, rsp.Tracing.Retries, 1)
+
+	// retry sleep is canceled by context
+	b.MinSleep = time.Second
+	b.IgnoreRetryAfter = true
+	req.SetRetries(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	atomic.StoreInt32(&count, 0)
+	_, err = req.Do(ctx, "GET", ts.URL)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
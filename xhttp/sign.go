@@ -0,0 +1,347 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likexian/gokit/xcache"
+	"github.com/likexian/gokit/xhash"
+	"github.com/likexian/gokit/xrand"
+)
+
+// Signing scheme
+const (
+	SignVersion = "v1"
+	SignHeader  = "X-Http-Gokit-Signature"
+)
+
+// Signer is signing request with HMAC-SHA256 over method, path, query, headers and body digest
+type Signer struct {
+	KeyId   string
+	Key     string
+	Headers []string
+}
+
+// Verifier is verifying signed request, Keys is map of key id to key for key rotation,
+// Nonces is store of used nonce for rejecting replay
+type Verifier struct {
+	Keys    map[string]string
+	Headers []string
+	MaxSkew time.Duration
+	MaxBody int64
+	Nonces  xcache.Cachex
+	mutex   sync.Mutex
+}
+
+// signKeyId is context key of verified key id
+type signKeyId struct{}
+
+// NewSigner returns a new signer, Host and Content-Type header are signed if headers is not set
+func NewSigner(keyId, key string, headers ...string) *Signer {
+	if len(headers) == 0 {
+		headers = []string{"Host", "Content-Type"}
+	}
+
+	return &Signer{
+		KeyId:   keyId,
+		Key:     key,
+		Headers: headers,
+	}
+}
+
+// NewVerifier returns a new verifier, allows 300 seconds clock skew and 10 MB body,
+// nonces are stored in memory cache
+func NewVerifier(keys map[string]string, headers ...string) *Verifier {
+	return &Verifier{
+		Keys:    keys,
+		Headers: headers,
+		MaxSkew: 300 * time.Second,
+		MaxBody: 10 << 20,
+		Nonces:  xcache.New(xcache.MemoryCache),
+	}
+}
+
+// SetSigner set signer of request, every attempt is signed with new timestamp and nonce
+func (r *Request) SetSigner(s *Signer) *Request {
+	r.Signer = s
+	return r
+}
+
+// Sign set signature header to http request, body is read by GetBody for digest
+func (s *Signer) Sign(req *http.Request) error {
+	digest, err := signBodyDigest(req)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := xrand.Hex(16)
+	if err != nil {
+		return fmt.Errorf("xhttp: generate nonce failed: %s", err.Error())
+	}
+
+	headers := sortHeaders(s.Headers)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	text := signText(req, s.KeyId, ts, nonce, headers, digest)
+	signature := xhash.HmacSha256(s.Key, text).Hex()
+
+	req.Header.Set(SignHeader, fmt.Sprintf("%s keyid=%s, ts=%s, nonce=%s, headers=%s, signature=%s",
+		SignVersion, s.KeyId, ts, nonce, strings.Join(headers, ";"), signature))
+
+	return nil
+}
+
+// Verify returns the key id if request signature is valid, the nonce is remembered for rejecting replay
+func (v *Verifier) Verify(req *http.Request) (keyId string, err error) {
+	fields, err := parseSignHeader(req.Header.Get(SignHeader))
+	if err != nil {
+		return
+	}
+
+	keyId = fields["keyid"]
+	key, ok := v.Keys[keyId]
+	if !ok {
+		return "", fmt.Errorf("xhttp: unknown signing key id: %s", keyId)
+	}
+
+	ts, err := strconv.ParseInt(fields["ts"], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("xhttp: signing timestamp invalid")
+	}
+
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > v.MaxSkew || skew < -v.MaxSkew {
+		return "", fmt.Errorf("xhttp: signing timestamp expired")
+	}
+
+	nonce := fields["nonce"]
+	if nonce == "" {
+		return "", fmt.Errorf("xhttp: signing nonce invalid")
+	}
+
+	headers := []string{}
+	if fields["headers"] != "" {
+		headers = strings.Split(fields["headers"], ";")
+	}
+
+	for _, h := range v.Headers {
+		found := false
+		for _, hh := range headers {
+			if strings.EqualFold(h, hh) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("xhttp: header %s is not signed", h)
+		}
+	}
+
+	digest, err := v.readBodyDigest(req)
+	if err != nil {
+		return "", err
+	}
+
+	text := signText(req, keyId, fields["ts"], nonce, headers, digest)
+	signature := xhash.HmacSha256(key, text).Hex()
+	if !hmac.Equal([]byte(signature), []byte(fields["signature"])) {
+		return "", fmt.Errorf("xhttp: signature not matched")
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	nonceKey := fmt.Sprintf("xhttp-nonce:%s:%s", keyId, nonce)
+	if v.Nonces.Has(nonceKey) {
+		return "", fmt.Errorf("xhttp: signing nonce is used")
+	}
+
+	err = v.Nonces.Set(nonceKey, ts, int64(2*v.MaxSkew/time.Second)+1)
+	if err != nil {
+		return "", fmt.Errorf("xhttp: store signing nonce failed: %s", err.Error())
+	}
+
+	return keyId, nil
+}
+
+// VerifyWrap is http signature verifying middleware, not verified request is responded with 401
+func VerifyWrap(next http.Handler, v *Verifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyId, err := v.Verify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signKeyId{}, keyId)))
+	})
+}
+
+// SignKeyId returns the key id of request verified by VerifyWrap
+func SignKeyId(r *http.Request) string {
+	if v, ok := r.Context().Value(signKeyId{}).(string); ok {
+		return v
+	}
+
+	return ""
+}
+
+// signText returns the text for signing
+func signText(req *http.Request, keyId, ts, nonce string, headers []string, digest string) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	lines := []string{
+		SignVersion,
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		keyId,
+		ts,
+		nonce,
+		strings.Join(headers, ";"),
+	}
+
+	for _, v := range headers {
+		value := ""
+		if v == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		} else {
+			value = strings.Join(req.Header[http.CanonicalHeaderKey(v)], ",")
+		}
+		lines = append(lines, v+":"+strings.TrimSpace(value))
+	}
+
+	lines = append(lines, digest)
+
+	return strings.Join(lines, "\n")
+}
+
+// signBodyDigest returns sha256 hex of request body, the body must be replayable,
+// it is reset by GetBody after reading since the body may share the reader
+func signBodyDigest(req *http.Request) (string, error) {
+	h := sha256.New()
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	if req.GetBody == nil {
+		return "", fmt.Errorf("xhttp: sign not replayable body is not supported")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return "", fmt.Errorf("xhttp: get body for signing failed: %s", err.Error())
+	}
+
+	_, err = io.Copy(h, body)
+	body.Close()
+	if err != nil {
+		return "", fmt.Errorf("xhttp: read body for signing failed: %s", err.Error())
+	}
+
+	req.Body.Close()
+	req.Body, err = req.GetBody()
+	if err != nil {
+		return "", fmt.Errorf("xhttp: get body for signing failed: %s", err.Error())
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readBodyDigest returns sha256 hex of request body, the body is reset for next reading
+func (v *Verifier) readBodyDigest(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(sha256.New().Sum(nil)), nil
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(req.Body, v.MaxBody+1))
+	req.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("xhttp: read body failed: %s", err.Error())
+	}
+
+	if int64(len(b)) > v.MaxBody {
+		return "", fmt.Errorf("xhttp: body is larger than %d bytes", v.MaxBody)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// parseSignHeader returns fields of signature header
+func parseSignHeader(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("xhttp: missing signature")
+	}
+
+	if !strings.HasPrefix(s, SignVersion+" ") {
+		return nil, fmt.Errorf("xhttp: signature version not supported")
+	}
+
+	fields := map[string]string{}
+	for _, v := range strings.Split(s[len(SignVersion)+1:], ",") {
+		kv := strings.SplitN(strings.TrimSpace(v), "=", 2)
+		if len(kv) == 2 {
+			fields[strings.ToLower(kv[0])] = kv[1]
+		}
+	}
+
+	keys := []string{"keyid", "ts", "nonce", "headers", "signature"}
+	for _, k := range keys {
+		if _, ok := fields[k]; !ok {
+			return nil, fmt.Errorf("xhttp: signature invalid, missing %s", k)
+		}
+	}
+
+	return fields, nil
+}
+
+// sortHeaders returns lowercase and sorted header names
+func sortHeaders(headers []string) []string {
+	r := make([]string, len(headers))
+	for i, v := range headers {
+		r[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+
+	sort.Strings(r)
+
+	return r
+}
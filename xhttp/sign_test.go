@@ -0,0 +1,191 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// signServer returns test server verifying signature, the signed requests are sent to ch
+func signServer(v *Verifier, ch chan *http.Request) *httptest.Server {
+	return httptest.NewServer(VerifyWrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if ch != nil {
+			ch <- &http.Request{Method: r.Method, URL: r.URL, Host: r.Host, Header: cloneHeader(r.Header)}
+		}
+		fmt.Fprintf(w, "%s:%s", SignKeyId(r), body)
+	}), v))
+}
+
+func TestParseSignHeader(t *testing.T) {
+	tests := []string{
+		"",
+		"v2 keyid=a, ts=1, nonce=a, headers=, signature=a",
+		"v1 keyid=a, ts=1, nonce=a, headers=",
+		"v1",
+	}
+
+	for _, v := range tests {
+		_, err := parseSignHeader(v)
+		assert.NotNil(t, err, v)
+	}
+
+	fields, err := parseSignHeader("v1 keyid=a, ts=1, nonce=b, headers=host;x-test, signature=c=")
+	assert.Nil(t, err)
+	assert.Equal(t, fields, map[string]string{"keyid": "a", "ts": "1", "nonce": "b",
+		"headers": "host;x-test", "signature": "c="})
+}
+
+func TestSign(t *testing.T) {
+	v := NewVerifier(map[string]string{"k1": "secret1", "k2": "secret2"}, "Host")
+	ts := signServer(v, nil)
+	defer ts.Close()
+
+	ctx := context.Background()
+	req := New().SetSigner(NewSigner("k1", "secret1"))
+	assert.Equal(t, req.Clone().Signer, req.Signer)
+
+	rsp, err := req.Post(ctx, ts.URL+"/path/a%20b?b=2&a=1", FormParam{"name": "likexian"})
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.Equal(t, text, "k1:name=likexian")
+
+	rsp, err = req.Put(ctx, ts.URL, strings.NewReader("likexian"), Header{"Content-Type": "text/plain"})
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.Equal(t, text, "k1:likexian")
+
+	rsp, err = req.Post(ctx, ts.URL, FormPart{Name: "file", FileName: "a.txt", Reader: strings.NewReader("likexian")})
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.Contains(t, text, "likexian")
+
+	req = New().SetSigner(NewSigner("k2", "secret2", "Host", "X-Test"))
+	rsp, err = req.Get(ctx, ts.URL, Header{"X-Test": "test"})
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "k2:")
+
+	for _, signer := range []*Signer{NewSigner("k1", "secret2"), NewSigner("k3", "secret1"), NewSigner("k1", "secret1", "X-Test")} {
+		rsp, err = New().SetSigner(signer).Get(ctx, ts.URL)
+		assert.Nil(t, err)
+		rsp.Close()
+		assert.Equal(t, rsp.StatusCode, http.StatusUnauthorized)
+	}
+
+	rsp, err = New().Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusUnauthorized)
+
+	_, err = req.Post(ctx, ts.URL, bytes.NewBufferString("likexian"))
+	assert.NotNil(t, err)
+}
+
+func TestSignReplay(t *testing.T) {
+	ch := make(chan *http.Request, 1)
+	v := NewVerifier(map[string]string{"k1": "secret1"})
+	ts := signServer(v, ch)
+	defer ts.Close()
+
+	req := New().SetSigner(NewSigner("k1", "secret1"))
+	rsp, err := req.Post(context.Background(), ts.URL, FormParam{"name": "likexian"})
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+
+	signed := <-ch
+	r := httptest.NewRequest("POST", signed.URL.String(), strings.NewReader("name=likexian"))
+	r.Host = signed.Host
+	r.Header = signed.Header
+	_, err = v.Verify(r)
+	assert.Equal(t, err.Error(), "xhttp: signing nonce is used")
+
+	r = httptest.NewRequest("POST", signed.URL.String(), strings.NewReader("name=tampered"))
+	r.Host = signed.Host
+	r.Header = signed.Header
+	_, err = v.Verify(r)
+	assert.Equal(t, err.Error(), "xhttp: signature not matched")
+
+	r = httptest.NewRequest("POST", signed.URL.String()+"?a=1", strings.NewReader("name=likexian"))
+	r.Host = signed.Host
+	r.Header = signed.Header
+	_, err = v.Verify(r)
+	assert.Equal(t, err.Error(), "xhttp: signature not matched")
+
+	v.MaxSkew = time.Second
+	r = httptest.NewRequest("POST", ts.URL, nil)
+	r.Header.Set(SignHeader, fmt.Sprintf("v1 keyid=k1, ts=%d, nonce=a, headers=, signature=a", time.Now().Unix()-10))
+	_, err = v.Verify(r)
+	assert.Equal(t, err.Error(), "xhttp: signing timestamp expired")
+
+	v.MaxBody = 4
+	r = httptest.NewRequest("POST", ts.URL, strings.NewReader("likexian"))
+	r.Header.Set(SignHeader, fmt.Sprintf("v1 keyid=k1, ts=%d, nonce=a, headers=, signature=a", time.Now().Unix()))
+	_, err = v.Verify(r)
+	assert.Equal(t, err.Error(), "xhttp: body is larger than 4 bytes")
+}
+
+func TestSignRetries(t *testing.T) {
+	var mutex sync.Mutex
+	var called int32
+	nonces := map[string]bool{}
+
+	v := NewVerifier(map[string]string{"k1": "secret1"})
+	ts := httptest.NewServer(VerifyWrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		nonces[r.Header.Get(SignHeader)] = true
+		mutex.Unlock()
+		if atomic.AddInt32(&called, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), v))
+	defer ts.Close()
+
+	policy := NewBackoff()
+	policy.MinSleep = time.Millisecond
+	req := New().SetSigner(NewSigner("k1", "secret1")).SetRetries(3, policy)
+	rsp, err := req.Post(context.Background(), ts.URL, FormParam{"name": "likexian"})
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.Equal(t, len(nonces), 3)
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likexian/gokit/xjson"
+)
+
+// defaultEventRetry is default reconnection time of event source
+const defaultEventRetry = 3 * time.Second
+
+// Event is server-sent event, Event is "message" if not set, Retry is reconnection time in milliseconds
+type Event struct {
+	Id    string
+	Event string
+	Data  string
+	Retry int64
+}
+
+// EventReader is reader of server-sent events
+type EventReader struct {
+	lines  *lineReader
+	lastId string
+	retry  int64
+}
+
+// NDJSONReader is reader of newline-delimited JSON
+type NDJSONReader struct {
+	lines *lineReader
+}
+
+// EventSource is server-sent events client, it reconnects after Retry when the stream is broken,
+// and resumes with Last-Event-ID, the consecutive failures over MaxRetries stop it, 0 is not limited
+type EventSource struct {
+	Request     *Request
+	URL         string
+	Args        []interface{}
+	LastEventId string
+	Retry       time.Duration
+	MaxRetries  int
+}
+
+// lineReader is reader of lines with max line size
+type lineReader struct {
+	reader  *bufio.Reader
+	body    io.ReadCloser
+	maxSize int64
+	started bool
+	cr      bool
+}
+
+// Events returns server-sent events reader of response body
+func (r *Response) Events() *EventReader {
+	return &EventReader{
+		lines: newLineReader(r.Response.Body, r.maxSize),
+	}
+}
+
+// NDJSON returns newline-delimited JSON reader of response body
+func (r *Response) NDJSON() *NDJSONReader {
+	return &NDJSONReader{
+		lines: newLineReader(r.Response.Body, r.maxSize),
+	}
+}
+
+// NewEventSource returns a new server-sent events client of url, args are passed to Do
+func (r *Request) NewEventSource(surl string, args ...interface{}) *EventSource {
+	return &EventSource{
+		Request: r,
+		URL:     surl,
+		Args:    args,
+		Retry:   defaultEventRetry,
+	}
+}
+
+// Next returns the next event, io.EOF is returned at the end of stream
+func (e *EventReader) Next() (*Event, error) {
+	var data bytes.Buffer
+	hasData := false
+	event := ""
+
+	for {
+		line, err := e.lines.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if line == "" {
+			if !hasData {
+				event = ""
+				continue
+			}
+			if event == "" {
+				event = "message"
+			}
+			return &Event{
+				Id:    e.lastId,
+				Event: event,
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+				Retry: e.retry,
+			}, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				e.lastId = value
+			}
+		case "retry":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				e.retry = n
+			}
+		}
+	}
+}
+
+// LastEventId returns the last event id
+func (e *EventReader) LastEventId() string {
+	return e.lastId
+}
+
+// Close close the response body
+func (e *EventReader) Close() error {
+	return e.lines.body.Close()
+}
+
+// Next returns the next JSON value, empty lines are skipped, io.EOF is returned at the end of stream
+func (n *NDJSONReader) Next() (*xjson.Json, error) {
+	for {
+		line, err := n.lines.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		j, err := xjson.Loads(line)
+		if err != nil {
+			return nil, fmt.Errorf("xhttp: decode ndjson failed: %s", err.Error())
+		}
+
+		return j, nil
+	}
+}
+
+// Close close the response body
+func (n *NDJSONReader) Close() error {
+	return n.lines.body.Close()
+}
+
+// Subscribe connect to server and call fn for every event until ctx is done or fn returns error,
+// status 204 stops it without error, other non 200 status and non event-stream response are errors
+func (s *EventSource) Subscribe(ctx context.Context, fn func(*Event) error) error {
+	req := s.Request.Clone()
+	req.Client.Timeout = 0
+	req.Caching.Method = map[string]int64{}
+	req.Dumping.DumpBody = false
+
+	failures := 0
+	for {
+		err := s.subscribe(ctx, req, fn, &failures)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.MaxRetries > 0 && failures > s.MaxRetries {
+			return fmt.Errorf("xhttp: event source failed after %d retries", s.MaxRetries)
+		}
+
+		err = sleepContext(ctx, s.Retry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// subscribe connect to server once and read events, returns nil error if it should reconnect
+func (s *EventSource) subscribe(ctx context.Context, req *Request, fn func(*Event) error, failures *int) error {
+	header := Header{
+		"Accept":        "text/event-stream",
+		"Cache-Control": "no-cache",
+	}
+
+	if s.LastEventId != "" {
+		header["Last-Event-ID"] = s.LastEventId
+	}
+
+	rsp, err := req.Get(ctx, s.URL, append(append([]interface{}{}, s.Args...), header)...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		*failures++
+		return nil
+	}
+
+	defer rsp.Close()
+
+	if rsp.StatusCode == http.StatusNoContent {
+		return io.EOF
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("xhttp: event source returns %s", rsp.Response.Status)
+	}
+
+	if !isMediaType(rsp.GetHeader("Content-Type"), "event-stream") {
+		return fmt.Errorf("xhttp: event source content type is %s", rsp.GetHeader("Content-Type"))
+	}
+
+	*failures = 0
+	events := rsp.Events()
+	for {
+		e, err := events.Next()
+		s.LastEventId = events.LastEventId()
+		if events.retry > 0 {
+			s.Retry = time.Duration(events.retry) * time.Millisecond
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			*failures++
+			return nil
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// newLineReader returns a new line reader of body
+func newLineReader(body io.ReadCloser, maxSize int64) *lineReader {
+	return &lineReader{
+		reader:  bufio.NewReader(body),
+		body:    body,
+		maxSize: maxSize,
+	}
+}
+
+// next returns the next line without line ending, the line ending is CRLF, LF or CR,
+// the BOM of first line is removed
+func (l *lineReader) next() (string, error) {
+	var line []byte
+	for {
+		c, err := l.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+
+		if c == '\n' && l.cr && len(line) == 0 {
+			l.cr = false
+			continue
+		}
+
+		l.cr = c == '\r'
+		if c == '\n' || c == '\r' {
+			break
+		}
+
+		line = append(line, c)
+		if l.maxSize > 0 && int64(len(line)) > l.maxSize {
+			return "", fmt.Errorf("xhttp: line size exceeds %d bytes", l.maxSize)
+		}
+	}
+
+	if !l.started {
+		l.started = true
+		line = bytes.TrimPrefix(line, []byte("\xef\xbb\xbf"))
+	}
+
+	return string(line), nil
+}
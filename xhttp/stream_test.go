@@ -0,0 +1,237 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func streamResponse(body string, maxSize int64) *Response {
+	return &Response{
+		Response: &http.Response{Body: ioutil.NopCloser(strings.NewReader(body))},
+		maxSize:  maxSize,
+	}
+}
+
+func TestEventReader(t *testing.T) {
+	body := "\xef\xbb\xbf: comment\r\n" +
+		"data: first\r\n" +
+		"\r\n" +
+		"event: update\n" +
+		"id: 1\n" +
+		"retry: 100\n" +
+		"data:line1\n" +
+		"data: line2\n" +
+		"\n" +
+		"event: ignored\n" +
+		"id: 2\n" +
+		"\n" +
+		"data\n" +
+		"unknown: field\n" +
+		"retry: bad\n" +
+		"\n" +
+		"data: incomplete\n"
+
+	events := streamResponse(body, 0).Events()
+	defer events.Close()
+
+	e, err := events.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, *e, Event{Event: "message", Data: "first"})
+
+	e, err = events.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, *e, Event{Id: "1", Event: "update", Data: "line1\nline2", Retry: 100})
+
+	e, err = events.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, *e, Event{Id: "2", Event: "message", Data: "", Retry: 100})
+	assert.Equal(t, events.LastEventId(), "2")
+
+	_, err = events.Next()
+	assert.Equal(t, err, io.EOF)
+
+	events = streamResponse("data: "+strings.Repeat("x", 100)+"\n\n", 50).Events()
+	_, err = events.Next()
+	assert.NotNil(t, err)
+
+	// the line ending is CR only
+	events = streamResponse("event: update\rdata: cr1\r\rdata: cr2\r\n\r\n\rdata: cr3\r\r", 0).Events()
+	for _, v := range []Event{{Event: "update", Data: "cr1"}, {Event: "message", Data: "cr2"}, {Event: "message", Data: "cr3"}} {
+		e, err = events.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, *e, v)
+	}
+	_, err = events.Next()
+	assert.Equal(t, err, io.EOF)
+
+	// the event is returned without waiting for the byte after CR
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	events = (&Response{Response: &http.Response{Body: pr}}).Events()
+	go pw.Write([]byte("data: live\r\r"))
+	e, err = events.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, e.Data, "live")
+}
+
+func TestNDJSONReader(t *testing.T) {
+	body := `{"id": 1, "name": "likexian"}` + "\n\n" + `[1, 2]` + "\r\n" + `{"id": 3}`
+
+	lines := streamResponse(body, 0).NDJSON()
+	defer lines.Close()
+
+	j, err := lines.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, j.Get("id").MustInt(), 1)
+	assert.Equal(t, j.Get("name").MustString(), "likexian")
+
+	j, err = lines.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, j.Len(), 2)
+
+	j, err = lines.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, j.Get("id").MustInt(), 3)
+
+	_, err = lines.Next()
+	assert.Equal(t, err, io.EOF)
+
+	lines = streamResponse("{\n", 0).NDJSON()
+	_, err = lines.Next()
+	assert.NotNil(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, `{"id": %d}`+"\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	rsp, err := New().Get(context.Background(), ts.URL)
+	assert.Nil(t, err)
+	lines = rsp.NDJSON()
+	defer lines.Close()
+	for i := 0; i < 3; i++ {
+		j, err := lines.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, j.Get("id").MustInt(), i)
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	var called int32
+	lastIds := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&called, 1)
+		lastIds <- r.Header.Get("Last-Event-ID")
+		if n == 3 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "retry: 10\n\n")
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, "id: %d-%d\ndata: event %d-%d\n\n", n, i, n, i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	s := New().SetClientTimeout(1).NewEventSource(ts.URL)
+	s.Retry = time.Hour
+
+	events := []string{}
+	err := s.Subscribe(ctx, func(e *Event) error {
+		events = append(events, e.Id+" "+e.Data)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, events, []string{"1-0 event 1-0", "1-1 event 1-1", "2-0 event 2-0", "2-1 event 2-1"})
+	assert.Equal(t, <-lastIds, "")
+	assert.Equal(t, <-lastIds, "1-1")
+	assert.Equal(t, <-lastIds, "2-1")
+	assert.Equal(t, s.LastEventId, "2-1")
+	assert.Equal(t, s.Retry, 10*time.Millisecond)
+
+	atomic.StoreInt32(&called, 0)
+	s = New().NewEventSource(ts.URL)
+	err = s.Subscribe(ctx, func(e *Event) error {
+		return fmt.Errorf("stop")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, err.Error(), "stop")
+}
+
+func TestEventSourceError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/text":
+			w.Write([]byte("data: text\n\n"))
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	err := New().NewEventSource(ts.URL+"/404").Subscribe(ctx, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "404")
+
+	err = New().NewEventSource(ts.URL+"/text").Subscribe(ctx, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "content type")
+
+	s := New().NewEventSource("http://127.0.0.1:1/")
+	s.Retry = time.Millisecond
+	s.MaxRetries = 2
+	err = s.Subscribe(ctx, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "after 2 retries")
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = New().NewEventSource(ts.URL).Subscribe(ctx, nil)
+	assert.Equal(t, err, context.DeadlineExceeded)
+
+	s = New().NewEventSource("http://127.0.0.1:1/")
+	err = s.Subscribe(ctx, nil)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
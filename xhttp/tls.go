@@ -0,0 +1,326 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+
+	"github.com/likexian/gokit/xhash"
+)
+
+// Securing storing client certificates and SPKI pins of request, the client certificates
+// are reloaded from disk by ReloadTLS without rebuilding the client
+type Securing struct {
+	mutex    sync.RWMutex
+	keyPairs [][2]string
+	certs    []tls.Certificate
+	pins     map[string][]string
+}
+
+// LoadClientCert load client certificate and key pair from PEM files for mutual TLS,
+// the certificate issued by the CA accepted by server is sent if multiple pairs are loaded
+func (r *Request) LoadClientCert(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("xhttp: load client cert failed: %s", err.Error())
+	}
+
+	s := r.securing()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keyPairs = append(s.keyPairs, [2]string{certFile, keyFile})
+	s.certs = append(s.certs, cert)
+
+	return nil
+}
+
+// LoadRootCA load CA certificates from PEM files, the certificates are added to the system roots,
+// unlike ReloadTLS it changes the TLS config, so do not call it while requests are running
+func (r *Request) LoadRootCA(files ...string) error {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, v := range files {
+		data, err := ioutil.ReadFile(v)
+		if err != nil {
+			return fmt.Errorf("xhttp: load root ca failed: %s", err.Error())
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("xhttp: load root ca failed: no certificate in %s", v)
+		}
+	}
+
+	r.Client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+
+	return nil
+}
+
+// SetTLSPin set SPKI SHA-256 pins of host in hex or base64, the certificate chain of connection to host
+// must have one of the pins, it is checked before the request is written, including the redirects followed
+// by client and the connection over proxy, only the leaf certificate is checked if verifying is disabled
+// by SetVerifyTls(false), no pin removes the pinning of host
+func (r *Request) SetTLSPin(host string, pins ...string) *Request {
+	s := r.securing()
+	s.mutex.Lock()
+
+	if s.pins == nil {
+		s.pins = map[string][]string{}
+	}
+
+	host = strings.ToLower(host)
+	if len(pins) == 0 {
+		delete(s.pins, host)
+	} else {
+		s.pins[host] = append([]string{}, pins...)
+	}
+
+	s.mutex.Unlock()
+
+	return r
+}
+
+// SetTLSVersion set minimum TLS version, for example tls.VersionTLS12
+func (r *Request) SetTLSVersion(min uint16) *Request {
+	r.Client.Transport.(*http.Transport).TLSClientConfig.MinVersion = min
+	return r
+}
+
+// SetTLSCiphers set cipher suites of TLS 1.2 and below, for example tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+func (r *Request) SetTLSCiphers(ciphers ...uint16) *Request {
+	r.Client.Transport.(*http.Transport).TLSClientConfig.CipherSuites = append([]uint16{}, ciphers...)
+	return r
+}
+
+// ReloadTLS reload client certificates from disk, it is safe to call while requests are running,
+// the certificates are kept if any of them failed to load
+func (r *Request) ReloadTLS() error {
+	if r.Securing == nil {
+		return nil
+	}
+
+	return r.Securing.reload()
+}
+
+// securing returns TLS setting of request, it is created and bound to transport if not set
+func (r *Request) securing() *Securing {
+	if r.Securing == nil {
+		r.Securing = &Securing{}
+		r.Securing.bind(r.Client.Transport.(*http.Transport).TLSClientConfig)
+	}
+
+	return r.Securing
+}
+
+// bind set the client certificate callback of TLS config
+func (s *Securing) bind(config *tls.Config) {
+	config.GetClientCertificate = s.clientCert
+}
+
+// pin returns client sender checking the pins of host when the connection is got for request,
+// the connection is closed before the request is written if the certificate does not match
+func (r *Request) pin(client *http.Client) Sender {
+	s := r.Securing
+	if s == nil {
+		return client.Do
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		if !s.pinned() {
+			return client.Do(req)
+		}
+
+		target := req.URL
+		c := *client
+		c.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+			if client.CheckRedirect != nil {
+				if err := client.CheckRedirect(next, via); err != nil {
+					return err
+				}
+			} else if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			target = next.URL
+			return nil
+		}
+
+		var pinErr error
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if pinErr != nil || target.Scheme != "https" {
+					return
+				}
+				pinErr = s.verifyConn(target.Hostname(), info.Conn)
+				if pinErr != nil {
+					info.Conn.Close()
+				}
+			},
+		}
+
+		rsp, err := c.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if pinErr != nil {
+			if err == nil {
+				rsp.Body.Close()
+			}
+			return nil, pinErr
+		}
+
+		return rsp, err
+	}
+}
+
+// clone returns a copy of TLS setting
+func (s *Securing) clone() *Securing {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	c := &Securing{
+		keyPairs: append([][2]string{}, s.keyPairs...),
+		certs:    append([]tls.Certificate{}, s.certs...),
+		pins:     map[string][]string{},
+	}
+
+	for k, v := range s.pins {
+		c.pins[k] = v
+	}
+
+	return c
+}
+
+// reload reload client certificates from disk
+func (s *Securing) reload() error {
+	s.mutex.RLock()
+	keyPairs := append([][2]string{}, s.keyPairs...)
+	s.mutex.RUnlock()
+
+	certs := make([]tls.Certificate, 0, len(keyPairs))
+	for _, v := range keyPairs {
+		cert, err := tls.LoadX509KeyPair(v[0], v[1])
+		if err != nil {
+			return fmt.Errorf("xhttp: reload client cert failed: %s", err.Error())
+		}
+		certs = append(certs, cert)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.certs = certs
+
+	return nil
+}
+
+// clientCert returns client certificate issued by the CA accepted by server, the first one is default
+func (s *Securing) clientCert(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.certs) == 0 {
+		return &tls.Certificate{}, nil
+	}
+
+	for i := range s.certs {
+		for _, v := range s.certs[i].Certificate {
+			cert, err := x509.ParseCertificate(v)
+			if err != nil {
+				continue
+			}
+			for _, ca := range info.AcceptableCAs {
+				if bytes.Equal(cert.RawIssuer, ca) {
+					return &s.certs[i], nil
+				}
+			}
+		}
+	}
+
+	return &s.certs[0], nil
+}
+
+// pinned returns if any host is pinned
+func (s *Securing) pinned() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.pins) > 0
+}
+
+// verifyConn checks the certificate of TLS connection has one of pins of host, only the leaf
+// certificate is checked if the chain is not verified, as the others sent by server are not trusted
+func (s *Securing) verifyConn(host string, conn net.Conn) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	s.mutex.RLock()
+	pins, ok := s.pins[host]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return fmt.Errorf("xhttp: tls connection of %s is not available for pinning", host)
+	}
+
+	state := tlsConn.ConnectionState()
+	certs := []*x509.Certificate{}
+	for _, v := range state.VerifiedChains {
+		certs = append(certs, v...)
+	}
+
+	if len(certs) == 0 && len(state.PeerCertificates) > 0 {
+		certs = append(certs, state.PeerCertificates[0])
+	}
+
+	if len(certs) == 0 {
+		return fmt.Errorf("xhttp: no certificate from server")
+	}
+
+	if !matchPins(certs, pins) {
+		return fmt.Errorf("xhttp: certificate of %s does not match pins", host)
+	}
+
+	return nil
+}
+
+// matchPins returns if any SPKI SHA-256 of certificates is in pins
+func matchPins(certs []*x509.Certificate, pins []string) bool {
+	for _, v := range certs {
+		h := xhash.Sha256(v.RawSubjectPublicKeyInfo)
+		hex, b64 := h.Hex(), h.B64()
+		for _, p := range pins {
+			if strings.EqualFold(p, hex) || p == b64 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
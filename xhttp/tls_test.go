@@ -0,0 +1,390 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/gokit/xfile"
+	"github.com/likexian/gokit/xhash"
+)
+
+func writeClientCert(t *testing.T, name, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	err = xfile.WriteText(certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	assert.Nil(t, err)
+
+	b, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	err = xfile.WriteText(keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})))
+	assert.Nil(t, err)
+}
+
+func TestTLSClientCert(t *testing.T) {
+	defer os.RemoveAll("tmp-tls")
+
+	err := os.MkdirAll("tmp-tls", 0755)
+	assert.Nil(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ctx := context.Background()
+	req := New().SetVerifyTls(false).SetKeepAliveTimeout(0)
+
+	rsp, err := req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "anonymous")
+
+	err = req.LoadClientCert("tmp-tls/client.crt", "tmp-tls/client.key")
+	assert.NotNil(t, err)
+
+	writeClientCert(t, "likexian", "tmp-tls/client.crt", "tmp-tls/client.key")
+	err = req.LoadClientCert("tmp-tls/client.crt", "tmp-tls/client.key")
+	assert.Nil(t, err)
+
+	rsp, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+
+	writeClientCert(t, "gokit", "tmp-tls/client.crt", "tmp-tls/client.key")
+	clone := req.Clone()
+	err = req.ReloadTLS()
+	assert.Nil(t, err)
+
+	for _, v := range []*Request{req, clone} {
+		rsp, err = v.Get(ctx, ts.URL)
+		assert.Nil(t, err)
+		text, err = rsp.String()
+		assert.Nil(t, err)
+		if v == req {
+			assert.Equal(t, text, "gokit")
+		} else {
+			assert.Equal(t, text, "likexian")
+		}
+	}
+
+	err = xfile.WriteText("tmp-tls/client.key", "invalid")
+	assert.Nil(t, err)
+	err = req.ReloadTLS()
+	assert.NotNil(t, err)
+
+	rsp, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "gokit")
+
+	assert.Nil(t, New().ReloadTLS())
+}
+
+func TestTLSRootCA(t *testing.T) {
+	defer os.RemoveAll("tmp-tls-ca")
+
+	err := os.MkdirAll("tmp-tls-ca", 0755)
+	assert.Nil(t, err)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("likexian"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	_, err = New().Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+
+	req := New()
+	err = req.LoadRootCA("tmp-tls-ca/ca.pem")
+	assert.NotNil(t, err)
+
+	err = xfile.WriteText("tmp-tls-ca/ca.pem", "invalid")
+	assert.Nil(t, err)
+	err = req.LoadRootCA("tmp-tls-ca/ca.pem")
+	assert.NotNil(t, err)
+
+	err = xfile.WriteText("tmp-tls-ca/ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})))
+	assert.Nil(t, err)
+	err = req.LoadRootCA("tmp-tls-ca/ca.pem")
+	assert.Nil(t, err)
+
+	rsp, err := req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+}
+
+func TestTLSPin(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("likexian"))
+	}))
+	defer ts.Close()
+
+	spki := xhash.Sha256(ts.Certificate().RawSubjectPublicKeyInfo)
+	ctx := context.Background()
+
+	tests := []struct {
+		host string
+		pins []string
+		ok   bool
+	}{
+		{"127.0.0.1", []string{spki.Hex()}, true},
+		{"127.0.0.1", []string{"bad", spki.B64()}, true},
+		{"127.0.0.1", []string{"bad"}, false},
+		{"example.com", []string{"bad"}, true},
+		{"www.likexian.com", []string{"bad"}, true},
+	}
+
+	for _, v := range tests {
+		req := New().SetVerifyTls(false).SetTLSPin(v.host, v.pins...)
+		_, err := req.Get(ctx, ts.URL)
+		assert.Equal(t, err == nil, v.ok, v.host)
+	}
+
+	// the pins of dialed host are checked even if the certificate is not valid for it
+	addr := strings.TrimPrefix(ts.URL, "https://")
+	surl := strings.Replace(ts.URL, "127.0.0.1", "www.likexian.com", 1)
+	for _, v := range tests {
+		req := New().SetVerifyTls(false).SetHostAddr("www.likexian.com", addr).
+			SetTLSPin(v.host, v.pins...).SetTLSPin("www.likexian.com", v.pins...)
+		_, err := req.Get(ctx, surl)
+		assert.Equal(t, err == nil, v.ok && v.host == "127.0.0.1", v.host)
+	}
+
+	// the pinned connection is dialed with the timeout setting
+	req := New().SetVerifyTls(false).SetTLSPin("127.0.0.1", spki.Hex()).SetConnectTimeout(3)
+	_, err := req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+
+	// the pinned connection over http proxy is checked
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer conn.Close()
+		w.WriteHeader(http.StatusOK)
+		c, buf, _ := w.(http.Hijacker).Hijack()
+		defer c.Close()
+		go io.Copy(conn, buf)
+		io.Copy(c, conn)
+	}))
+	defer proxy.Close()
+
+	_, err = New().SetVerifyTls(false).SetProxyUrl(proxy.URL).Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	_, err = New().SetVerifyTls(false).SetProxyUrl(proxy.URL).SetTLSPin("127.0.0.1", spki.Hex()).Get(ctx, ts.URL)
+	assert.Nil(t, err)
+	_, err = New().SetVerifyTls(false).SetProxyUrl(proxy.URL).SetTLSPin("127.0.0.1", "bad").Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	_, err = New().SetVerifyTls(false).SetProxyUrl(proxy.URL).SetTLSPin("example.com", "bad").Get(ctx, ts.URL)
+	assert.Nil(t, err)
+
+	req = New().SetVerifyTls(false).SetTLSPin("127.0.0.1", "bad").SetTLSPin("127.0.0.1")
+	_, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+
+	clone := req.Clone().SetTLSPin("127.0.0.1", "bad")
+	_, err = clone.Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+	_, err = req.Get(ctx, ts.URL)
+	assert.Nil(t, err)
+}
+
+func TestTLSPinHost(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+			return
+		}
+		w.Write([]byte("likexian"))
+	}))
+	defer ts.Close()
+
+	spki := xhash.Sha256(ts.Certificate().RawSubjectPublicKeyInfo)
+	addr := strings.TrimPrefix(ts.URL, "https://")
+	surl := strings.Replace(ts.URL, "127.0.0.1", "www.likexian.com", 1)
+	ctx := context.Background()
+
+	// the unpinned host sharing the client is dialed by transport with the trace of context
+	req := New().SetVerifyTls(false).SetHostAddr("www.likexian.com", addr).SetTLSPin("www.likexian.com", "bad")
+	var tlsDone int32
+	tctx := httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			atomic.AddInt32(&tlsDone, 1)
+		},
+	})
+	rsp, err := req.Get(tctx, ts.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+	assert.Equal(t, atomic.LoadInt32(&tlsDone), int32(1))
+
+	_, err = req.Get(ctx, surl)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not match pins")
+
+	// the redirect to pinned host is checked
+	_, err = req.Get(ctx, ts.URL+"/redirect?to="+url.QueryEscape(surl))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not match pins")
+
+	req.SetTLSPin("www.likexian.com", spki.B64())
+	rsp, err = req.Get(ctx, ts.URL+"/redirect?to="+url.QueryEscape(surl))
+	assert.Nil(t, err)
+	text, err = rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+
+	// the context is canceled during the handshake of pinned host
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	startAt := time.Now()
+	_, err = New().SetTLSPin("127.0.0.1", spki.Hex()).Get(cctx, "https://"+ln.Addr().String())
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(startAt) < 3*time.Second)
+}
+
+func TestTLSPinLeaf(t *testing.T) {
+	pinned := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("likexian"))
+	}))
+	defer pinned.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	// the untrusted leaf is followed by a copy of the pinned certificate
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("attacker"))
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{der, pinned.Certificate().Raw},
+		PrivateKey:  key,
+	}}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	spki := xhash.Sha256(pinned.Certificate().RawSubjectPublicKeyInfo)
+	ctx := context.Background()
+
+	rsp, err := New().SetVerifyTls(false).SetTLSPin("127.0.0.1", spki.Hex()).Get(ctx, pinned.URL)
+	assert.Nil(t, err)
+	text, err := rsp.String()
+	assert.Nil(t, err)
+	assert.Equal(t, text, "likexian")
+
+	_, err = New().SetVerifyTls(false).SetTLSPin("127.0.0.1", spki.Hex()).Get(ctx, ts.URL)
+	assert.NotNil(t, err)
+}
+
+func TestTLSVersion(t *testing.T) {
+	req := New().SetTLSVersion(tls.VersionTLS12).
+		SetTLSCiphers(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+
+	config := req.Client.Transport.(*http.Transport).TLSClientConfig
+	assert.Equal(t, config.MinVersion, uint16(tls.VersionTLS12))
+	assert.Equal(t, config.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256})
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("likexian"))
+	}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	_, err := req.SetVerifyTls(false).Get(context.Background(), ts.URL)
+	assert.NotNil(t, err)
+
+	_, err = req.SetTLSCiphers(tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384).Get(context.Background(), ts.URL)
+	assert.Nil(t, err)
+}
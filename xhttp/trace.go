@@ -0,0 +1,139 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// phaseTrace storing time of request phases by httptrace
+type phaseTrace struct {
+	sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	reused       bool
+	idleTime     time.Duration
+	remoteAddr   string
+	resolve      string
+}
+
+// phaseContextKey is context key of phase trace
+type phaseContextKey struct{}
+
+// withPhaseTrace returns a shallow copy of request with httptrace for recording phases
+func withPhaseTrace(req *http.Request) (*http.Request, *phaseTrace) {
+	p := &phaseTrace{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.Lock()
+			defer p.Unlock()
+			p.reused = info.Reused
+			p.idleTime = info.IdleTime
+			if info.Conn != nil {
+				p.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			p.set(&p.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			p.set(&p.dnsDone)
+		},
+		ConnectStart: func(network, addr string) {
+			p.set(&p.connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				p.set(&p.connectDone)
+			}
+		},
+		TLSHandshakeStart: func() {
+			p.set(&p.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			p.set(&p.tlsDone)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			p.set(&p.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			p.set(&p.firstByte)
+		},
+	}
+
+	ctx := context.WithValue(httptrace.WithClientTrace(req.Context(), trace), phaseContextKey{}, p)
+	return req.WithContext(ctx), p
+}
+
+// set set the time to now, the first connecting time is kept for dialing in parallel
+func (p *phaseTrace) set(t *time.Time) {
+	p.Lock()
+	defer p.Unlock()
+
+	if t == &p.connectStart && !t.IsZero() {
+		return
+	}
+
+	*t = time.Now()
+}
+
+// setResolve set the resolve status
+func (p *phaseTrace) setResolve(status string) {
+	p.Lock()
+	defer p.Unlock()
+	p.resolve = status
+}
+
+// fill set the phase time to attempt
+func (p *phaseTrace) fill(a *Attempt) {
+	p.Lock()
+	defer p.Unlock()
+
+	a.DNSTime = phaseTime(p.dnsStart, p.dnsDone)
+	a.ConnectTime = phaseTime(p.connectStart, p.connectDone)
+	a.TLSTime = phaseTime(p.tlsStart, p.tlsDone)
+	a.ServerTime = phaseTime(p.wroteRequest, p.firstByte)
+	a.FirstByteTime = phaseTime(p.start, p.firstByte)
+	a.ConnReused = p.reused
+	a.ConnIdleTime = int64(p.idleTime / time.Millisecond)
+	a.RemoteAddr = p.remoteAddr
+	a.ResolveStatus = p.resolve
+}
+
+// phaseTime returns milliseconds from start to end, returns 0 if phase is not happened
+func phaseTime(start, end time.Time) int64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+
+	return int64(end.Sub(start) / time.Millisecond)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestPhaseTime(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, phaseTime(now, now.Add(10*time.Millisecond)), int64(10))
+	assert.Equal(t, phaseTime(now, now.Add(-10*time.Millisecond)), int64(0))
+	assert.Equal(t, phaseTime(time.Time{}, now), int64(0))
+	assert.Equal(t, phaseTime(now, time.Time{}), int64(0))
+
+	p := &phaseTrace{
+		start:        now,
+		dnsStart:     now,
+		dnsDone:      now.Add(1 * time.Millisecond),
+		connectStart: now.Add(1 * time.Millisecond),
+		connectDone:  now.Add(3 * time.Millisecond),
+		tlsStart:     now.Add(3 * time.Millisecond),
+		tlsDone:      now.Add(7 * time.Millisecond),
+		wroteRequest: now.Add(8 * time.Millisecond),
+		firstByte:    now.Add(20 * time.Millisecond),
+		idleTime:     5 * time.Second,
+		remoteAddr:   "127.0.0.1:443",
+	}
+
+	p.set(&p.connectStart)
+	assert.Equal(t, p.connectStart, now.Add(1*time.Millisecond))
+
+	a := Attempt{}
+	p.fill(&a)
+	assert.Equal(t, a, Attempt{
+		DNSTime:       1,
+		ConnectTime:   2,
+		TLSTime:       4,
+		ServerTime:    12,
+		FirstByteTime: 20,
+		ConnIdleTime:  5000,
+		RemoteAddr:    "127.0.0.1:443",
+	})
+}
+
+func TestPhaseTrace(t *testing.T) {
+	var called int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		if atomic.AddInt32(&called, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	policy := NewBackoff()
+	policy.MinSleep = time.Millisecond
+	req := New().SetVerifyTls(false).SetRetries(1, policy)
+
+	surl := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	rsp, err := req.Get(context.Background(), surl)
+	assert.Nil(t, err)
+	rsp.Close()
+	assert.Equal(t, rsp.StatusCode, http.StatusOK)
+	assert.Equal(t, len(rsp.Tracing.Attempts), 2)
+
+	first, second := rsp.Tracing.Attempts[0], rsp.Tracing.Attempts[1]
+	assert.False(t, first.ConnReused)
+	assert.True(t, second.ConnReused)
+	assert.NotEqual(t, first.RemoteAddr, "")
+	assert.Equal(t, first.RemoteAddr, second.RemoteAddr)
+	assert.Ge(t, first.ServerTime, int64(25))
+	assert.Ge(t, first.FirstByteTime, first.ServerTime)
+	assert.Ge(t, first.FirstByteTime, first.DNSTime+first.ConnectTime+first.TLSTime)
+	assert.Equal(t, second.DNSTime, int64(0))
+	assert.Equal(t, second.ConnectTime, int64(0))
+	assert.Equal(t, second.TLSTime, int64(0))
+	assert.Ge(t, second.ServerTime, int64(25))
+}
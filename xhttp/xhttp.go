@@ -26,14 +26,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
-	"net/http/httputil"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -42,6 +39,7 @@ import (
 	"github.com/likexian/gokit/xfile"
 	"github.com/likexian/gokit/xhash"
 	"github.com/likexian/gokit/xjson"
+	"github.com/likexian/gokit/xlog"
 	"github.com/likexian/gokit/xrand"
 	"github.com/likexian/gokit/xtime"
 )
@@ -58,42 +56,92 @@ type Timeout struct {
 
 // Retries storing retry setting
 type Retries struct {
-	Times int
-	Sleep time.Duration
+	Times  int
+	Sleep  time.Duration
+	Policy RetryPolicy
 }
 
-// Dumping storing http dump setting
+// Dumping storing http dump setting, the secrets in RedactHeaders, RedactQuery and RedactFields are redacted,
+// body exceeded MaxBody is truncated, 0 is the default 64KB, the structured dump is written to Logger if set
 type Dumping struct {
-	DumpHttp bool
-	DumpBody bool
+	DumpHttp      bool
+	DumpBody      bool
+	MaxBody       int64
+	RedactHeaders []string
+	RedactQuery   []string
+	RedactFields  []string
+	Logger        *xlog.Logger
 }
 
-// Caching storing cache method and ttl
+// Resolving storing resolver setting, the host in Hosts connects to the addresses without DNS lookup,
+// other hosts are resolved by Resolver if set
+type Resolving struct {
+	Hosts    map[string][]string
+	Resolver *Resolver
+	next     uint32
+}
+
+// Caching storing cache method, ttl and backend
 type Caching struct {
 	Method map[string]int64
+	Cache  xcache.Cachex
 }
 
 // Request storing request data
 type Request struct {
-	ClientId  string
-	Request   *http.Request
-	Client    *http.Client
-	ClientKey string
-	Timeout   Timeout
-	Caching   Caching
-	Retries   Retries
-	Dumping   Dumping
+	ClientId     string
+	Request      *http.Request
+	Client       *http.Client
+	ClientKey    string
+	Timeout      Timeout
+	Caching      Caching
+	Retries      Retries
+	Dumping      Dumping
+	Limiting     Limiting
+	Breaker      *Breaker
+	Signer       *Signer
+	Auth         Authenticator
+	ProxyPool    *ProxyPool
+	Socks5       *Socks5Dialer
+	Resolving    Resolving
+	Securing     *Securing
+	Metrics      *Metrics
+	HAR          *HAR
+	Decoding     Decoding
+	Interceptors []Interceptor
 }
 
 // Tracing storing tracing data
 type Tracing struct {
-	ClientId  string
-	RequestId string
-	Timestamp string
-	Nonce     string
-	SendTime  int64
-	RecvTime  int64
-	Retries   int
+	ClientId    string
+	RequestId   string
+	Timestamp   string
+	Nonce       string
+	SendTime    int64
+	RecvTime    int64
+	Retries     int
+	WaitTime    int64
+	Attempts    []Attempt
+	CacheStatus string
+}
+
+// Attempt storing tracing data of every attempt, time is in milliseconds,
+// the phases of DNS, connect and TLS are 0 if connection is reused
+type Attempt struct {
+	StatusCode    int
+	Error         string
+	WaitTime      int64
+	SendTime      int64
+	SleepTime     int64
+	DNSTime       int64
+	ConnectTime   int64
+	TLSTime       int64
+	ServerTime    int64
+	FirstByteTime int64
+	ConnReused    bool
+	ConnIdleTime  int64
+	RemoteAddr    string
+	ResolveStatus string
 }
 
 // Response storing response data
@@ -106,6 +154,8 @@ type Response struct {
 	CacheKey      string
 	Tracing       Tracing
 	Dumping       [][]byte
+	DumpInfo      *DumpInfo
+	maxSize       int64
 }
 
 // Host is http host
@@ -185,13 +235,11 @@ var (
 		"DELETE",
 		"OPTIONS",
 	}
-	// Caching is http request cache
-	caching = xcache.New(xcache.MemoryCache)
 )
 
 // Version returns package version
 func Version() string {
-	return "0.17.0"
+	return "0.41.0"
 }
 
 // Author returns package author
@@ -223,6 +271,7 @@ func New() (r *Request) {
 
 	client := &http.Client{
 		Transport: &http.Transport{
+			DialContext:        resolveDial((&net.Dialer{}).DialContext),
 			TLSClientConfig:    &tls.Config{InsecureSkipVerify: false},
 			DisableCompression: false,
 		},
@@ -232,20 +281,122 @@ func New() (r *Request) {
 		Method: map[string]int64{},
 	}
 
+	dumping := Dumping{
+		MaxBody:       defaultDumpSize,
+		RedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	}
+
 	r = &Request{
-		ClientId:  xhash.Sha1("xhttp", xtime.Ns()).Hex(),
-		Request:   request,
-		Client:    client,
-		ClientKey: "",
-		Timeout:   timeout,
-		Caching:   cache,
-		Retries:   Retries{},
-		Dumping:   Dumping{},
+		ClientId:     xhash.Sha1("xhttp", xtime.Ns()).Hex(),
+		Request:      request,
+		Client:       client,
+		ClientKey:    "",
+		Timeout:      timeout,
+		Caching:      cache,
+		Retries:      Retries{},
+		Dumping:      dumping,
+		Limiting:     Limiting{limiter: newLimiter()},
+		Decoding:     Decoding{MaxSize: defaultDecodeSize},
+		Interceptors: []Interceptor{},
 	}
 
 	return
 }
 
+// Clone returns a copy of request, the copy can be changed without affecting the origin
+func (r *Request) Clone() *Request {
+	request := &http.Request{
+		Header: cloneHeader(r.Request.Header),
+		Host:   r.Request.Host,
+	}
+
+	client := &http.Client{
+		Transport:     r.Client.Transport,
+		CheckRedirect: r.Client.CheckRedirect,
+		Jar:           r.Client.Jar,
+		Timeout:       r.Client.Timeout,
+	}
+
+	var securing *Securing
+	if t, ok := r.Client.Transport.(*http.Transport); ok {
+		n := cloneTransport(t)
+		if r.Securing != nil && n.TLSClientConfig != nil {
+			securing = r.Securing.clone()
+			securing.bind(n.TLSClientConfig)
+		}
+		client.Transport = n
+	}
+
+	cache := Caching{
+		Method: map[string]int64{},
+		Cache:  r.Caching.Cache,
+	}
+
+	for k, v := range r.Caching.Method {
+		cache.Method[k] = v
+	}
+
+	resolving := Resolving{
+		Hosts:    map[string][]string{},
+		Resolver: r.Resolving.Resolver,
+	}
+
+	for k, v := range r.Resolving.Hosts {
+		resolving.Hosts[k] = v
+	}
+
+	return &Request{
+		ClientId:     r.ClientId,
+		Request:      request,
+		Client:       client,
+		ClientKey:    r.ClientKey,
+		Timeout:      r.Timeout,
+		Caching:      cache,
+		Retries:      r.Retries,
+		Dumping:      r.Dumping.clone(),
+		Limiting:     r.Limiting,
+		Breaker:      r.Breaker,
+		Signer:       r.Signer,
+		Auth:         r.Auth,
+		ProxyPool:    r.ProxyPool,
+		Socks5:       r.Socks5,
+		Resolving:    resolving,
+		Securing:     securing,
+		Metrics:      r.Metrics,
+		HAR:          r.HAR,
+		Decoding:     r.Decoding,
+		Interceptors: append([]Interceptor{}, r.Interceptors...),
+	}
+}
+
+// cloneTransport returns a copy of http transport
+func cloneTransport(t *http.Transport) *http.Transport {
+	n := &http.Transport{
+		Proxy:                  t.Proxy,
+		DialContext:            t.DialContext,
+		Dial:                   t.Dial,
+		DialTLS:                t.DialTLS,
+		TLSHandshakeTimeout:    t.TLSHandshakeTimeout,
+		DisableKeepAlives:      t.DisableKeepAlives,
+		DisableCompression:     t.DisableCompression,
+		MaxIdleConns:           t.MaxIdleConns,
+		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        t.MaxConnsPerHost,
+		IdleConnTimeout:        t.IdleConnTimeout,
+		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
+		ExpectContinueTimeout:  t.ExpectContinueTimeout,
+		TLSNextProto:           t.TLSNextProto,
+		ProxyConnectHeader:     cloneHeader(t.ProxyConnectHeader),
+		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
+	}
+
+	if t.TLSClientConfig != nil {
+		n.TLSClientConfig = t.TLSClientConfig.Clone()
+	}
+
+	return n
+}
+
 // Get do http GET request and returns response
 func Get(ctx context.Context, surl string, args ...interface{}) (s *Response, err error) {
 	return DefaultRequest.Do(ctx, "GET", surl, args...)
@@ -276,6 +427,11 @@ func Delete(ctx context.Context, surl string, args ...interface{}) (s *Response,
 	return DefaultRequest.Do(ctx, "DELETE", surl, args...)
 }
 
+// Download do http GET request and save response to file
+func Download(ctx context.Context, surl, fpath string, args ...interface{}) (size int64, err error) {
+	return DefaultRequest.Download(ctx, surl, fpath, args...)
+}
+
 // Options do http OPTIONS request and returns response
 func Options(ctx context.Context, surl string, args ...interface{}) (s *Response, err error) {
 	return DefaultRequest.Do(ctx, "OPTIONS", surl, args...)
@@ -392,10 +548,7 @@ func (r *Request) SetTimeout(timeout Timeout) *Request {
 	} else {
 		r.Client.Transport.(*http.Transport).DisableKeepAlives = false
 	}
-	r.Client.Transport.(*http.Transport).DialContext = (&net.Dialer{
-		Timeout:   time.Duration(r.Timeout.ConnectTimeout) * time.Second,
-		KeepAlive: time.Duration(r.Timeout.KeepAliveTimeout) * time.Second,
-	}).DialContext
+	r.setDialer()
 	r.Client.Transport.(*http.Transport).TLSHandshakeTimeout = time.Duration(r.Timeout.TLSHandshakeTimeout) * time.Second
 	r.Client.Transport.(*http.Transport).ResponseHeaderTimeout = time.Duration(r.Timeout.ResponseHeaderTimeout) * time.Second
 	r.Client.Transport.(*http.Transport).ExpectContinueTimeout = time.Duration(r.Timeout.ExpectContinueTimeout) * time.Second
@@ -403,6 +556,27 @@ func (r *Request) SetTimeout(timeout Timeout) *Request {
 	return r
 }
 
+// setDialer set dialer of transport with timeout setting, it is connected via SOCKS5 proxy if set,
+// the SOCKS5 dialer is copied as it may be shared by cloned requests, and its handshake is
+// limited by connect timeout
+func (r *Request) setDialer() {
+	timeout := time.Duration(r.Timeout.ConnectTimeout) * time.Second
+	dial := resolveDial((&net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: time.Duration(r.Timeout.KeepAliveTimeout) * time.Second,
+	}).DialContext)
+
+	if r.Socks5 != nil {
+		d := *r.Socks5
+		d.Timeout = timeout
+		d.Forward = dial
+		r.Socks5 = &d
+		dial = d.DialContext
+	}
+
+	r.Client.Transport.(*http.Transport).DialContext = dial
+}
+
 // GetTimeout get http request timeout
 func (r *Request) GetTimeout() Timeout {
 	return r.Timeout
@@ -442,7 +616,8 @@ func (r *Request) FollowRedirect(follow bool) *Request {
 	return r
 }
 
-// EnableCookie set http request enable cookie
+// EnableCookie set http request enable cookie, the cookies are kept in memory,
+// use SetCookieJar with NewCookieJar for saving cookies to file
 func (r *Request) EnableCookie(enable bool) *Request {
 	if enable {
 		if r.Client.Jar == nil {
@@ -457,11 +632,16 @@ func (r *Request) EnableCookie(enable bool) *Request {
 	return r
 }
 
-// EnableCache enable http client cache
+// EnableCache enable http client cache by method, cache follows the RFC 7234,
+// ttl is seconds of freshness when response has no explicit expiration time,
+// the responses are cached in memory of request if no cache backend is set by SetCache
 func (r *Request) EnableCache(method string, ttl int64) *Request {
 	method = strings.ToUpper(strings.TrimSpace(method))
 	if assert.IsContains(supportMethod, method) {
 		r.Caching.Method[method] = ttl
+		if r.Caching.Cache == nil {
+			r.Caching.Cache = xcache.New(xcache.MemoryCache)
+		}
 	}
 
 	return r
@@ -470,43 +650,55 @@ func (r *Request) EnableCache(method string, ttl int64) *Request {
 // SetRetries set retry param
 // int arg is setting retry times, time.Duration is setting retry sleep duration
 // 0: no retry (default), -1: retry until success, > 1: retry x times
+// RetryPolicy arg is setting retry policy, without it only retry on error with fixed sleep
 func (r *Request) SetRetries(args ...interface{}) *Request {
 	if len(args) == 0 {
 		panic("xhttp: no args pass to SetRetries")
 	}
 
 	for i := 0; i < len(args); i++ {
-		switch args[i].(type) {
+		switch v := args[i].(type) {
 		case int:
-			r.Retries.Times = args[i].(int)
+			r.Retries.Times = v
 		case time.Duration:
-			r.Retries.Sleep = args[i].(time.Duration)
+			r.Retries.Sleep = v
+		case RetryPolicy:
+			r.Retries.Policy = v
 		}
 	}
 
 	return r
 }
 
-// SetDump set http dump
+// SetDump set http dump, the default secret headers are redacted and body is truncated to 64KB
 func (r *Request) SetDump(dumpHttp, dumpBody bool) *Request {
 	r.Dumping.DumpHttp = dumpHttp
 	r.Dumping.DumpBody = dumpBody
 	return r
 }
 
+// newRequest returns a new http request base on the request template
+func (r *Request) newRequest(ctx context.Context, method string) *http.Request {
+	req := &http.Request{
+		Method:     method,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     cloneHeader(r.Request.Header),
+		Host:       r.Request.Host,
+	}
+
+	return req.WithContext(ctx)
+}
+
 // Do send http request and return response
+// every call builds its own http request, so it is safe for concurrent use
 func (r *Request) Do(ctx context.Context, method, surl string, args ...interface{}) (s *Response, err error) {
-	r.Request.Host = ""
-	r.Request.Header.Del("Cookie")
-	r.Request.Header.Del("Content-Type")
-
 	method = strings.ToUpper(strings.TrimSpace(method))
 	if !assert.IsContains(supportMethod, method) {
 		return nil, fmt.Errorf("xhttp: not supported method: %s", method)
 	}
 
-	r.Request.Method = method
-
 	surl = strings.TrimSpace(surl)
 	if surl == "" {
 		return nil, fmt.Errorf("xhttp: no request url specify")
@@ -515,27 +707,32 @@ func (r *Request) Do(ctx context.Context, method, surl string, args ...interface
 	var formBody string
 	var formParam param
 	var queryParam param
+	var formReader io.Reader
+	var formPart []FormPart
+	var progress ProgressFunc
 
 	formFile := FormFile{}
+	client := r.Client
+	req := r.newRequest(ctx, method)
 
 	for _, v := range args {
 		switch vv := v.(type) {
 		case Host:
-			r.SetHost(string(vv))
+			req.Host = string(vv)
 		case Header:
 			for k, v := range vv {
-				r.SetHeader(k, v)
+				req.Header.Set(k, v)
 			}
 		case http.Header:
 			for k, v := range vv {
 				for _, vv := range v {
-					r.SetHeader(k, vv)
+					req.Header.Set(k, vv)
 				}
 			}
 		case *http.Client:
-			r.Client = vv
+			client = vv
 		case *http.Cookie:
-			r.Request.AddCookie(vv)
+			req.AddCookie(vv)
 		case FormParam:
 			formParam.Adds(vv)
 		case QueryParam:
@@ -551,63 +748,55 @@ func (r *Request) Do(ctx context.Context, method, surl string, args ...interface
 			if err != nil {
 				return nil, fmt.Errorf("xhttp: encode json param failed: %s", err.Error())
 			}
-			r.Request.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Type", "application/json")
 		case string:
 			formBody = vv
 		case []byte:
 			formBody = string(vv)
 		case bytes.Buffer:
 			formBody = vv.String()
+		case io.Reader:
+			formReader = vv
 		case FormFile:
 			for k, v := range vv {
 				formFile[k] = v
 			}
+		case FormPart:
+			formPart = append(formPart, vv)
+		case []FormPart:
+			formPart = append(formPart, vv...)
+		case ProgressFunc:
+			progress = vv
 		}
 	}
 
-	r.Request = r.Request.WithContext(ctx)
-	r.Request.Body = nil
-	r.Request.ContentLength = 0
-
 	if assert.IsContains([]string{"POST", "PUT", "PATCH"}, method) {
-		if len(formFile) > 0 {
-			pr, pw := io.Pipe()
-			bw := multipart.NewWriter(pw)
-			go func() {
-				for k, v := range formFile {
-					fw, err := bw.CreateFormFile(k, v)
-					if err != nil {
-						continue
-					}
-					fd, err := os.Open(v)
-					if err != nil {
-						continue
-					}
-					_, err = io.Copy(fw, fd)
-					fd.Close()
-					if err != nil {
-						continue
-					}
-				}
-				for k, v := range formParam.Values {
-					for _, vv := range v {
-						_ = bw.WriteField(k, vv)
-					}
-				}
-				bw.Close()
-				pw.Close()
-			}()
-			r.SetHeader("Content-Type", bw.FormDataContentType())
-			r.Request.Body = ioutil.NopCloser(pr)
+		if len(formFile) > 0 || len(formPart) > 0 {
+			err = setMultipartBody(req, formFile, formPart, formParam)
+			if err != nil {
+				return nil, err
+			}
+		} else if formReader != nil {
+			err = setReaderBody(req, formReader)
+			if err != nil {
+				return nil, err
+			}
+			if req.Header.Get("Content-Type") == "" {
+				req.Header.Set("Content-Type", "application/octet-stream")
+			}
 		} else {
 			if !formParam.IsEmpty() {
 				formBody += formParam.Encode()
 			}
 			if formBody != "" {
-				r.Request.Body = ioutil.NopCloser(bytes.NewReader([]byte(formBody)))
-				r.Request.ContentLength = int64(len(formBody))
-				if r.Request.Header.Get("Content-Type") == "" {
-					r.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				body := []byte(formBody)
+				req.GetBody = func() (io.ReadCloser, error) {
+					return ioutil.NopCloser(bytes.NewReader(body)), nil
+				}
+				req.Body, _ = req.GetBody()
+				req.ContentLength = int64(len(body))
+				if req.Header.Get("Content-Type") == "" {
+					req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 				}
 			}
 		}
@@ -622,15 +811,15 @@ func (r *Request) Do(ctx context.Context, method, surl string, args ...interface
 		}
 	}
 
-	u, err := url.Parse(surl)
+	req.URL, err = url.Parse(surl)
 	if err != nil {
 		return nil, fmt.Errorf("xhttp: parse url failed: %s", err.Error())
 	}
-	r.Request.URL = u
 
 	s = &Response{
-		Method: r.Request.Method,
-		URL:    r.Request.URL,
+		Method:  req.Method,
+		URL:     req.URL,
+		maxSize: r.Decoding.MaxSize,
 		Tracing: Tracing{
 			Timestamp: fmt.Sprintf("%d", xtime.S()),
 			Nonce:     fmt.Sprintf("%d", xrand.IntRange(1000000, 9999999)),
@@ -639,52 +828,46 @@ func (r *Request) Do(ctx context.Context, method, surl string, args ...interface
 		},
 	}
 
+	metrics := r.Metrics
+	if metrics != nil {
+		metrics.begin(s)
+	}
+
 	startAt := xtime.Ms()
 	defer func() {
 		s.Tracing.SendTime = xtime.Ms() - startAt
+		if metrics != nil {
+			metrics.observe(s, err)
+		}
+		if r.Dumping.DumpHttp {
+			r.Dumping.logDump(s, err)
+		}
 	}()
 
 	s.Tracing.RequestId = xhash.Sha1("xhttp", s.Tracing.Timestamp,
 		s.Tracing.Nonce, s.Method, s.URL.Path, s.URL.RawQuery, r.ClientKey).Hex()
-	r.Request.Header.Set("X-HTTP-GoKit-RequestId", fmt.Sprintf("%s-%s-%s", s.Tracing.Timestamp,
+	req.Header.Set("X-HTTP-GoKit-RequestId", fmt.Sprintf("%s-%s-%s", s.Tracing.Timestamp,
 		s.Tracing.Nonce, s.Tracing.RequestId))
 
+	var entry *cacheEntry
+	cacheHit := false
 	cacheTTL, cacheEnabled := r.Caching.Method[s.Method]
-	if cacheEnabled || r.Dumping.DumpHttp {
-		dumpBody := r.Dumping.DumpBody
-		if cacheEnabled {
-			dumpBody = true
-		}
-		d, err := httputil.DumpRequestOut(r.Request, dumpBody)
-		if err == nil {
-			s.Dumping = append(s.Dumping, d)
-		}
+	if cacheEnabled && r.Caching.Cache != nil && formReader == nil && len(formFile) == 0 && len(formPart) == 0 && !isNoStore(req.Header) {
+		s.CacheKey = xhash.Sha1(s.Method, s.URL.String(), formBody).Hex()
+		entry, cacheHit = r.cacheLookup(req, s, cacheTTL)
 	}
 
-	if cacheEnabled {
-		body := ""
-		if len(s.Dumping) > 0 {
-			d := strings.Split(string(s.Dumping[0]), "\r\n\r\n")
-			if len(d) > 1 {
-				body = d[1]
-			}
-			s.CacheKey = xhash.Sha1(s.Method, s.URL.String(), body).Hex()
-			cacheVal := caching.Get(s.CacheKey)
-			if cacheVal != nil {
-				s = cacheVal.(*Response)
-				return
-			}
-		}
+	if r.Dumping.DumpHttp {
+		r.Dumping.dumpRequest(req, s)
 	}
 
-	for i := 0; r.Retries.Times == -1 || i <= r.Retries.Times; i++ {
-		s.Tracing.Retries += 1
-		s.Response, err = r.Client.Do(r.Request)
-		if err == nil {
-			break
-		}
-		if r.Retries.Sleep > 0 {
-			time.Sleep(r.Retries.Sleep)
+	withProgress(req, progress)
+
+	if !cacheHit {
+		requestTime := time.Now().Unix()
+		err = r.send(ctx, client, req, s)
+		if err == nil && s.CacheKey != "" {
+			err = r.cacheUpdate(req, s, entry, cacheTTL, requestTime)
 		}
 	}
 
@@ -694,14 +877,18 @@ func (r *Request) Do(ctx context.Context, method, surl string, args ...interface
 	}
 
 	if r.Dumping.DumpHttp {
-		d, err := httputil.DumpResponse(s.Response, r.Dumping.DumpBody)
-		if err == nil {
-			s.Dumping = append(s.Dumping, d)
+		r.Dumping.dumpSent(sentRequest(req, s), s)
+		if s.Response != nil {
+			r.Dumping.dumpResponse(s)
 		}
 	}
 
-	if s.CacheKey != "" {
-		_ = caching.Set(s.CacheKey, s, cacheTTL)
+	if r.HAR != nil {
+		r.HAR.record(req, s, &r.Dumping, startAt, err)
+	}
+
+	if err == nil && r.Decoding.HTTPError {
+		err = newHTTPError(s)
 	}
 
 	return
@@ -718,38 +905,33 @@ func (r *Response) GetHeader(name string) string {
 }
 
 // File save response body to file
-func (r *Response) File(paths ...string) (size int64, err error) {
+// string arg is the file path, Checksum arg is verifying file digest, ProgressFunc arg is download progress
+// body is written to temp file and renamed when finished, partial content is appended to temp file for resuming
+func (r *Response) File(args ...interface{}) (size int64, err error) {
 	fpath := ""
-	if len(paths) > 0 {
-		fpath = paths[0]
-	}
+	var checksum *Checksum
+	var progress ProgressFunc
 
-	fpath = strings.TrimSpace(fpath)
-	if fpath == "" {
-		_, fpath = filepath.Split(r.URL.String())
-		if fpath == "" {
-			fpath = "index.html"
-		}
-	} else {
-		dir, name := filepath.Split(fpath)
-		if name == "" {
-			fpath = dir + "index.html"
-		}
-		if dir != "" && !xfile.Exists(dir) {
-			err = os.MkdirAll(dir, 0755)
-			if err != nil {
-				return
-			}
+	for _, v := range args {
+		switch vv := v.(type) {
+		case string:
+			fpath = vv
+		case Checksum:
+			checksum = &vv
+		case ProgressFunc:
+			progress = vv
 		}
 	}
 
-	if xfile.Exists(fpath) {
-		return 0, fmt.Errorf("file %s is exists", fpath)
+	defer r.Response.Body.Close()
+
+	fpath, err = downloadPath(fpath, r.URL)
+	if err != nil {
+		return
 	}
 
-	defer r.Response.Body.Close()
-	if r.Response.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("bad status code: %d", r.Response.StatusCode)
+	if xfile.Exists(fpath) {
+		return 0, fmt.Errorf("file %s is exists", fpath)
 	}
 
 	startAt := xtime.Ms()
@@ -757,13 +939,37 @@ func (r *Response) File(paths ...string) (size int64, err error) {
 		r.Tracing.RecvTime = xtime.Ms() - startAt
 	}()
 
-	fd, err := xfile.New(fpath)
+	tpath := fpath + downloadSuffix
+	offset, total, err := downloadRange(r.Response, tpath)
+	if err != nil {
+		return
+	}
+
+	if r.Response.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		err = writeDownload(tpath, offset, total, r.Response.Body, progress)
+		if err != nil {
+			return
+		}
+	}
+
+	size, err = xfile.Size(tpath)
 	if err != nil {
 		return
 	}
 
-	defer fd.Close()
-	size, err = io.Copy(fd, r.Response.Body)
+	if total >= 0 && size != total {
+		return size, fmt.Errorf("xhttp: download incomplete, %d of %d bytes", size, total)
+	}
+
+	if checksum != nil {
+		err = checksum.verify(tpath)
+		if err != nil {
+			os.Remove(tpath)
+			return 0, err
+		}
+	}
+
+	err = os.Rename(tpath, fpath)
 
 	return
 }
@@ -778,10 +984,6 @@ func (r *Response) Bytes() (b []byte, err error) {
 	b, err = ioutil.ReadAll(r.Response.Body)
 	r.Response.Body.Close()
 
-	if r.CacheKey != "" {
-		r.Response.Body = ioutil.NopCloser(bytes.NewBuffer(b))
-	}
-
 	return
 }
 
@@ -814,6 +1016,8 @@ func (r *Response) Dump() [][]byte {
 
 // CheckClient returns is a valid client request
 // used by http server, it will check the requestId
+//
+// Deprecated: the requestId is not keyed and can be replayed, use SetSigner and VerifyWrap instead.
 func CheckClient(r *http.Request, ClientKey string) error {
 	id := r.Header.Get("X-Http-Gokit-Requestid")
 	if id == "" {
@@ -849,6 +1053,7 @@ func CheckClient(r *http.Request, ClientKey string) error {
 }
 
 // GetClientIPs returns all ips from http client
+// the headers are not verified, use ClientIPResolver for trusted proxies
 func GetClientIPs(r *http.Request) []string {
 	ips := []string{}
 
@@ -864,7 +1069,7 @@ func GetClientIPs(r *http.Request) []string {
 		}
 	}
 
-	ips = append(ips, strings.Split(r.RemoteAddr, ":")[0])
+	ips = append(ips, parseNodeIP(r.RemoteAddr))
 
 	return ips
 }
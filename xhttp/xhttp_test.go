@@ -52,10 +52,10 @@ func TestNew(t *testing.T) {
 	req := New()
 	ctx := context.Background()
 
-	_, err := req.Do(ctx, "GET", LOCALURL)
+	rsp, err := req.Do(ctx, "GET", LOCALURL)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.Method, "GET")
-	assert.Equal(t, req.Request.URL.String(), LOCALURL)
+	assert.Equal(t, rsp.Method, "GET")
+	assert.Equal(t, rsp.URL.String(), LOCALURL)
 
 	_, err = req.Do(ctx, "CODE", LOCALURL)
 	assert.NotNil(t, err)
@@ -64,15 +64,15 @@ func TestNew(t *testing.T) {
 	_, err = req.Do(ctx, "GET", "::")
 	assert.NotNil(t, err)
 
-	_, err = req.Do(ctx, "get", LOCALURL)
+	rsp, err = req.Do(ctx, "get", LOCALURL)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.Method, "GET")
-	assert.Equal(t, req.Request.URL.String(), LOCALURL)
+	assert.Equal(t, rsp.Method, "GET")
+	assert.Equal(t, rsp.URL.String(), LOCALURL)
 
-	_, err = req.Do(ctx, "POST", LOCALURL+"post")
+	rsp, err = req.Do(ctx, "POST", LOCALURL+"post")
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.Method, "POST")
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.Method, "POST")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 
 	clientId := req.ClientId
 	req = New()
@@ -173,8 +173,11 @@ func TestSetHost(t *testing.T) {
 	assert.Equal(t, req.Request.Host, "likexian.com")
 	assert.NotEqual(t, req.Request.Host, host)
 
-	var h Host = "likexian.com"
-	_, _ = req.Do(ctx, "GET", LOCALURL, h)
+	var h Host = "likexian.org"
+	rsp, err := req.Do(ctx, "GET", LOCALURL, h)
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.Response.Request.Host, "likexian.org")
 	assert.Equal(t, req.Request.Host, "likexian.com")
 }
 
@@ -190,17 +193,25 @@ func TestSetHeader(t *testing.T) {
 	h1 := Header{
 		"X-Version": Version(),
 	}
-	_, _ = req.Do(ctx, "GET", LOCALURL, h1)
+	rsp, err := req.Do(ctx, "GET", LOCALURL, h1)
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.Response.Request.Header.Get("X-Author"), "likexian")
+	assert.Equal(t, rsp.Response.Request.Header.Get("X-Version"), Version())
 	assert.Equal(t, req.GetHeader("X-Author"), "likexian")
-	assert.Equal(t, req.GetHeader("X-Version"), Version())
+	assert.Equal(t, req.GetHeader("X-Version"), "")
 
 	h2 := http.Header{
 		"X-License": []string{License()},
 	}
-	_, _ = req.Do(ctx, "GET", LOCALURL, h2)
+	rsp, err = req.Do(ctx, "GET", LOCALURL, h2)
+	assert.Nil(t, err)
+	defer rsp.Close()
+	assert.Equal(t, rsp.Response.Request.Header.Get("X-Author"), "likexian")
+	assert.Equal(t, rsp.Response.Request.Header.Get("X-Version"), "")
+	assert.Equal(t, rsp.Response.Request.Header.Get("X-License"), License())
 	assert.Equal(t, req.GetHeader("X-Author"), "likexian")
-	assert.Equal(t, req.GetHeader("X-Version"), Version())
-	assert.Equal(t, req.GetHeader("X-License"), License())
+	assert.Equal(t, req.GetHeader("X-License"), "")
 }
 
 func TestSetUA(t *testing.T) {
@@ -521,59 +532,59 @@ func TestEnableCookie(t *testing.T) {
 	rsp, err := req.Do(ctx, "GET", LOCALURL+"cookies/set/k/v")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	// enable cookies
 	req.EnableCookie(true)
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies/set/k/v")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 1)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 1)
 
 	// delete cookies
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies/delete?k=")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 1)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 1)
 
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	// set cookie again
 	req.EnableCookie(true)
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies/set/k/v")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 1)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 1)
 
 	// disable cookies
 	req.EnableCookie(false)
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies/set/k/v")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	rsp, err = req.Do(ctx, "GET", LOCALURL+"cookies")
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 0)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 0)
 
 	// set cookies by args
 	cookie := &http.Cookie{Name: "k", Value: "likexian"}
@@ -581,7 +592,7 @@ func TestEnableCookie(t *testing.T) {
 	rsp, err = req.Do(ctx, "GET", LOCALURL, cookie)
 	assert.Nil(t, err)
 	defer rsp.Close()
-	assert.Equal(t, len(req.Request.Cookies()), 1)
+	assert.Equal(t, len(rsp.Response.Request.Cookies()), 1)
 }
 
 func TestQueryParam(t *testing.T) {
@@ -589,19 +600,19 @@ func TestQueryParam(t *testing.T) {
 	ctx := context.Background()
 
 	query := QueryParam{"k": "v"}
-	_, err := req.Do(ctx, "GET", LOCALURL+"get", query)
+	rsp, err := req.Do(ctx, "GET", LOCALURL+"get", query)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"get?k=v")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"get?k=v")
 
 	query = QueryParam{"a": "1", "b": 2, "c": 3}
-	_, err = req.Do(ctx, "GET", req.Request.URL.String(), query)
+	rsp, err = req.Do(ctx, "GET", rsp.URL.String(), query)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"get?k=v&a=1&b=2&c=3")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"get?k=v&a=1&b=2&c=3")
 
 	query = QueryParam{}
-	_, err = req.Do(ctx, "GET", LOCALURL+"get", query)
+	rsp, err = req.Do(ctx, "GET", LOCALURL+"get", query)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"get")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"get")
 }
 
 func TestFormParam(t *testing.T) {
@@ -611,15 +622,15 @@ func TestFormParam(t *testing.T) {
 	form := FormParam{"k": "v"}
 	rsp, err := req.Do(ctx, "POST", LOCALURL+"post", form)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err := rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("k.0").MustString(""), "v")
 
 	form = FormParam{"a": "1", "b": 2, "c": 3}
-	rsp, err = req.Do(ctx, "POST", req.Request.URL.String(), form)
+	rsp, err = req.Do(ctx, "POST", rsp.URL.String(), form)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err = rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("a.0").MustString(""), "1")
@@ -629,7 +640,7 @@ func TestFormParam(t *testing.T) {
 	form = FormParam{}
 	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", form)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err = rsp.Json()
 	assert.Nil(t, err)
 	m, _ := json.Get("form").Map()
@@ -638,7 +649,7 @@ func TestFormParam(t *testing.T) {
 	data := map[string]interface{}{"a": "1", "b": 2, "c": 3}
 	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", FormParam(data))
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err = rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("a.0").MustString(""), "1")
@@ -652,14 +663,14 @@ func TestValuesParam(t *testing.T) {
 	values := url.Values{"k": []string{"v"}}
 
 	// url.Values as query string
-	_, err := req.Do(ctx, "GET", LOCALURL+"get", values)
+	rsp, err := req.Do(ctx, "GET", LOCALURL+"get", values)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"get?k=v")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"get?k=v")
 
 	// url.Values as form data
-	rsp, err := req.Do(ctx, "POST", LOCALURL+"post", values)
+	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", values)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err := rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("k.0").MustString(""), "v")
@@ -672,15 +683,15 @@ func TestPostBody(t *testing.T) {
 	// Post string
 	rsp, err := req.Do(ctx, "POST", LOCALURL+"post", "k=v")
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err := rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("k.0").MustString(""), "v")
 
 	// Post []byte
-	rsp, err = req.Do(ctx, "POST", req.Request.URL.String(), []byte("a=1&b=2&c=3"))
+	rsp, err = req.Do(ctx, "POST", rsp.URL.String(), []byte("a=1&b=2&c=3"))
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err = rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("a.0").MustString(""), "1")
@@ -692,7 +703,7 @@ func TestPostBody(t *testing.T) {
 	b.Write([]byte("k=v"))
 	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", b)
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err = rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("form").Get("k.0").MustString(""), "v")
@@ -700,7 +711,7 @@ func TestPostBody(t *testing.T) {
 	// Post json string
 	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", `{"k": "v"}`, Header{"Content-Type": "application/json"})
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	json, err = rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, json.Get("json").Get("k").MustString(""), "v")
@@ -709,7 +720,7 @@ func TestPostBody(t *testing.T) {
 	data := map[string]interface{}{"a": "1", "b": 2, "c": 3}
 	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", JsonParam(data))
 	assert.Nil(t, err)
-	assert.Equal(t, req.Request.URL.String(), LOCALURL+"post")
+	assert.Equal(t, rsp.URL.String(), LOCALURL+"post")
 	j, err := rsp.Json()
 	assert.Nil(t, err)
 	assert.Equal(t, j.Get("url").MustString(""), LOCALURL+"post")
@@ -742,7 +753,7 @@ func TestPostFile(t *testing.T) {
 	assert.Contains(t, json.Get("file").Get("file_1").MustString(""), "")
 
 	// Test post file and form
-	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", FormParam{"k": "v"}, FormFile{"file": "../go.mod", "404": "404.md"})
+	rsp, err = req.Do(ctx, "POST", LOCALURL+"post", FormParam{"k": "v"}, FormFile{"file": "../go.mod"})
 	assert.Nil(t, err)
 	defer rsp.Close()
 	json, err = rsp.Json()
@@ -750,6 +761,10 @@ func TestPostFile(t *testing.T) {
 	assert.Contains(t, json.Get("headers.Content-Type.0").MustString(""), "multipart/form-data")
 	assert.Contains(t, json.Get("file").Get("file").MustString(""), "module github.com/likexian/gokit")
 	assert.Equal(t, json.Get("form").Get("k.0").MustString(""), "v")
+
+	// Test post not exists file
+	_, err = req.Do(ctx, "POST", LOCALURL+"post", FormParam{"k": "v"}, FormFile{"file": "../go.mod", "404": "404.md"})
+	assert.NotNil(t, err)
 }
 
 func TestWithCancel(t *testing.T) {
@@ -836,7 +851,7 @@ func TestEnableCache(t *testing.T) {
 	newText, err := newRsp.String()
 	assert.Nil(t, err)
 	assert.NotEqual(t, newText, text)
-	assert.NotEqual(t, newRsp.Tracing.RequestId, rsp.Tracing.RequestId)
+	assert.Equal(t, newRsp.Tracing.CacheStatus, "")
 
 	// enable get cache
 	req.EnableCache("GET", 300)
@@ -856,7 +871,7 @@ func TestEnableCache(t *testing.T) {
 	newText, err = newRsp.String()
 	assert.Nil(t, err)
 	assert.Equal(t, newText, text)
-	assert.Equal(t, newRsp.Tracing.RequestId, rsp.Tracing.RequestId)
+	assert.Equal(t, newRsp.Tracing.CacheStatus, CacheHit)
 
 	newRsp, err = req.Do(ctx, "GET", LOCALURL+"time", QueryParam{"q": "a"})
 	assert.Nil(t, err)
@@ -865,7 +880,7 @@ func TestEnableCache(t *testing.T) {
 	newText, err = newRsp.String()
 	assert.Nil(t, err)
 	assert.NotEqual(t, newText, text)
-	assert.NotEqual(t, newRsp.Tracing.RequestId, rsp.Tracing.RequestId)
+	assert.Equal(t, newRsp.Tracing.CacheStatus, CacheMiss)
 
 	// enable post cache
 	req.EnableCache("post", 300)
@@ -885,7 +900,7 @@ func TestEnableCache(t *testing.T) {
 	newText, err = newRsp.String()
 	assert.Nil(t, err)
 	assert.Equal(t, newText, text)
-	assert.Equal(t, newRsp.Tracing.RequestId, rsp.Tracing.RequestId)
+	assert.Equal(t, newRsp.Tracing.CacheStatus, CacheHit)
 
 	newRsp, err = req.Do(ctx, "POST", LOCALURL+"time", QueryParam{"q": "a"}, FormParam{"d": "v", "x": "likexian", "q": "a"})
 	assert.Nil(t, err)
@@ -894,7 +909,7 @@ func TestEnableCache(t *testing.T) {
 	newText, err = newRsp.String()
 	assert.Nil(t, err)
 	assert.NotEqual(t, newText, text)
-	assert.NotEqual(t, newRsp.Tracing.RequestId, rsp.Tracing.RequestId)
+	assert.Equal(t, newRsp.Tracing.CacheStatus, CacheMiss)
 }
 
 func TestCheckClient(t *testing.T) {
@@ -933,7 +948,7 @@ func TestCheckClient(t *testing.T) {
 	rsp, err := req.Do(ctx, "GET", LOCALURL)
 	assert.Nil(t, err)
 	defer rsp.Close()
-	err = CheckClient(req.Request, "")
+	err = CheckClient(rsp.Response.Request, "")
 	assert.Nil(t, err)
 }
 
@@ -960,6 +975,82 @@ func TestConcurrent(t *testing.T) {
 	wg.Wait()
 }
 
+func TestConcurrentShared(t *testing.T) {
+	var wg sync.WaitGroup
+	ctx := context.Background()
+
+	req := New().EnableCookie(true).SetRetries(1)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("%d", i)
+			rsp, err := req.Do(ctx, "POST", LOCALURL+"post", Header{"X-Test-Value": value},
+				QueryParam{"i": i}, FormParam{"i": i}, &http.Cookie{Name: "i", Value: value})
+			assert.Nil(t, err)
+			defer rsp.Close()
+			json, err := rsp.Json()
+			assert.Nil(t, err)
+			assert.Equal(t, json.Get("headers.X-Test-Value").MustStringArray(), []string{value})
+			assert.Equal(t, json.Get("headers.Cookie").MustStringArray(), []string{"i=" + value})
+			assert.Equal(t, json.Get("args.i").MustStringArray(), []string{value})
+			assert.Equal(t, json.Get("form.i").MustStringArray(), []string{value})
+		}(i)
+	}
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rsp, err := Get(ctx, LOCALURL+"get", Host(fmt.Sprintf("host-%d", i)))
+			assert.Nil(t, err)
+			defer rsp.Close()
+			json, err := rsp.Json()
+			assert.Nil(t, err)
+			assert.Equal(t, json.Get("url").MustString(""), fmt.Sprintf("http://host-%d/get", i))
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Equal(t, req.GetHeader("X-Test-Value"), "")
+	assert.Equal(t, req.GetHeader("Cookie"), "")
+}
+
+func TestClone(t *testing.T) {
+	req := New().SetUA("likexian").SetHost("likexian.com").EnableCache("GET", 60).SetRetries(3)
+	req.OnBeforeSend(func(r *http.Request) (*http.Response, error) {
+		return nil, nil
+	})
+
+	clone := req.Clone()
+	assert.Equal(t, clone.ClientId, req.ClientId)
+	assert.Equal(t, clone.GetHeader("User-Agent"), "likexian")
+	assert.Equal(t, clone.Request.Host, "likexian.com")
+	assert.Equal(t, clone.Caching.Method, req.Caching.Method)
+	assert.Equal(t, clone.Retries.Times, 3)
+	assert.Equal(t, len(clone.Interceptors), 1)
+
+	clone.SetUA("gokit").SetHost("").EnableCache("POST", 60).SetRetries(1).SetVerifyTls(false).SetClientTimeout(1)
+	clone.OnAfterRecv(func(r *http.Request, rsp *http.Response) (*http.Response, error) {
+		return nil, nil
+	})
+	assert.Equal(t, req.GetHeader("User-Agent"), "likexian")
+	assert.Equal(t, req.Request.Host, "likexian.com")
+	assert.Equal(t, len(req.Caching.Method), 1)
+	assert.Equal(t, req.Retries.Times, 3)
+	assert.Equal(t, len(req.Interceptors), 1)
+	assert.False(t, req.Client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	assert.True(t, clone.Client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, req.Client.Timeout, 0*time.Second)
+
+	rsp, err := clone.Get(context.Background(), LOCALURL+"get")
+	assert.Nil(t, err)
+	defer rsp.Close()
+	json, err := rsp.Json()
+	assert.Nil(t, err)
+	assert.Equal(t, json.Get("headers.User-Agent.0").MustString(""), "gokit")
+}
+
 func TestGetClientIPs(t *testing.T) {
 	u, _ := url.Parse(LOCALURL)
 	r := &http.Request{
@@ -983,6 +1074,11 @@ func TestGetClientIPs(t *testing.T) {
 	r.Header.Set("X-Forwarded-For", "2.2.2.2, 3.3.3.3")
 	ips = GetClientIPs(r)
 	assert.Equal(t, ips, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "127.0.0.1"})
+
+	r.Header = http.Header{}
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	ips = GetClientIPs(r)
+	assert.Equal(t, ips, []string{"2001:db8::1"})
 }
 
 func ServerForTesting(listen string) string {
@@ -0,0 +1,343 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttptest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// Faults of reply
+const (
+	// FaultNone is replying normally
+	FaultNone = iota
+	// FaultReset is resetting the connection without response
+	FaultReset
+	// FaultSlowBody is writing body byte by byte with BodyDelay
+	FaultSlowBody
+	// FaultPartialBody is writing half of body and closing the connection
+	FaultPartialBody
+)
+
+// Reply is stub response of route, Delay is delay before writing header
+type Reply struct {
+	Status    int
+	Header    map[string]string
+	Body      string
+	Delay     time.Duration
+	BodyDelay time.Duration
+	Fault     int
+}
+
+// Route is route of method and path, the replies are used in sequence and the last one is repeated,
+// method "*" is matching any method, path ending with "*" is matching prefix
+type Route struct {
+	Method  string
+	Path    string
+	Replies []Reply
+	calls   int
+}
+
+// Request is captured request
+type Request struct {
+	Method     string
+	URL        *url.URL
+	Path       string
+	Query      url.Values
+	Header     http.Header
+	Body       []byte
+	RemoteAddr string
+	Time       time.Time
+}
+
+// Server is local http server with scripted routes and captured requests
+type Server struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	routes   []*Route
+	requests []*Request
+}
+
+// Version returns package version
+func Version() string {
+	return "0.1.0"
+}
+
+// Author returns package author
+func Author() string {
+	return "[Li Kexian](https://www.likexian.com/)"
+}
+
+// License returns package license
+func License() string {
+	return "Licensed under the Apache License 2.0"
+}
+
+// NewServer returns a started local http server
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewTLSServer returns a started local https server, the client must use s.Client() or skip verifying
+func NewTLSServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Handle add route of method and path, the later added route is matched first
+func (s *Server) Handle(method, path string, replies ...Reply) *Route {
+	if len(replies) == 0 {
+		replies = []Reply{{Status: http.StatusOK}}
+	}
+
+	r := &Route{
+		Method:  strings.ToUpper(method),
+		Path:    path,
+		Replies: replies,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.routes = append([]*Route{r}, s.routes...)
+
+	return r
+}
+
+// Calls returns count of request matched the route
+func (s *Server) Calls(r *Route) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return r.calls
+}
+
+// Requests returns captured requests of method and path, all requests if method and path are empty
+func (s *Server) Requests(method, path string) []*Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rs := []*Request{}
+	for _, v := range s.requests {
+		if (method == "" || strings.EqualFold(v.Method, method)) && (path == "" || v.Path == path) {
+			rs = append(rs, v)
+		}
+	}
+
+	return rs
+}
+
+// LastRequest returns the last captured request of method and path, nil if not found
+func (s *Server) LastRequest(method, path string) *Request {
+	rs := s.Requests(method, path)
+	if len(rs) == 0 {
+		return nil
+	}
+
+	return rs[len(rs)-1]
+}
+
+// Reset remove all routes and captured requests
+func (s *Server) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.routes = nil
+	s.requests = nil
+}
+
+// AssertCalled assert count of captured requests of method and path
+func (s *Server) AssertCalled(t *testing.T, method, path string, times int) {
+	assert.Equal(t, len(s.Requests(method, path)), times, fmt.Sprintf("xhttptest: calls of %s %s", method, path))
+}
+
+// AssertHeader assert header of the last captured request of method and path
+func (s *Server) AssertHeader(t *testing.T, method, path, key, value string) {
+	r := s.LastRequest(method, path)
+	assert.True(t, r != nil, fmt.Sprintf("xhttptest: no request of %s %s", method, path))
+	assert.Equal(t, r.Header.Get(key), value, fmt.Sprintf("xhttptest: header %s of %s %s", key, method, path))
+}
+
+// AssertQuery assert query param of the last captured request of method and path
+func (s *Server) AssertQuery(t *testing.T, method, path, key, value string) {
+	r := s.LastRequest(method, path)
+	assert.True(t, r != nil, fmt.Sprintf("xhttptest: no request of %s %s", method, path))
+	assert.Equal(t, r.Query.Get(key), value, fmt.Sprintf("xhttptest: query %s of %s %s", key, method, path))
+}
+
+// AssertBody assert body of the last captured request of method and path
+func (s *Server) AssertBody(t *testing.T, method, path, body string) {
+	r := s.LastRequest(method, path)
+	assert.True(t, r != nil, fmt.Sprintf("xhttptest: no request of %s %s", method, path))
+	assert.Equal(t, string(r.Body), body, fmt.Sprintf("xhttptest: body of %s %s", method, path))
+}
+
+// match returns if the route is matched method and path
+func (r *Route) match(method, path string) bool {
+	if r.Method != "*" && r.Method != method {
+		return false
+	}
+
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(path, r.Path[:len(r.Path)-1])
+	}
+
+	return r.Path == path
+}
+
+// next returns the reply for next request
+func (r *Route) next() Reply {
+	reply := r.Replies[len(r.Replies)-1]
+	if r.calls < len(r.Replies) {
+		reply = r.Replies[r.calls]
+	}
+
+	r.calls++
+
+	return reply
+}
+
+// serveHTTP capture the request and write the reply of matched route
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	req := &Request{
+		Method:     r.Method,
+		URL:        r.URL,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Header:     r.Header,
+		Body:       body,
+		RemoteAddr: r.RemoteAddr,
+		Time:       time.Now(),
+	}
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, req)
+	var reply *Reply
+	for _, v := range s.routes {
+		if v.match(r.Method, r.URL.Path) {
+			rr := v.next()
+			reply = &rr
+			break
+		}
+	}
+	s.mutex.Unlock()
+
+	if reply == nil {
+		http.Error(w, fmt.Sprintf("xhttptest: no route of %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+		return
+	}
+
+	if !sleep(r, reply.Delay) {
+		return
+	}
+
+	switch reply.Fault {
+	case FaultReset:
+		resetConn(w)
+		return
+	case FaultPartialBody:
+		writeHeader(w, reply, len(reply.Body))
+		w.Write([]byte(reply.Body[:len(reply.Body)/2]))
+		w.(http.Flusher).Flush()
+		closeConn(w)
+		return
+	case FaultSlowBody:
+		writeHeader(w, reply, len(reply.Body))
+		for i := 0; i < len(reply.Body); i++ {
+			w.Write([]byte{reply.Body[i]})
+			w.(http.Flusher).Flush()
+			if !sleep(r, reply.BodyDelay) {
+				return
+			}
+		}
+	default:
+		writeHeader(w, reply, len(reply.Body))
+		w.Write([]byte(reply.Body))
+	}
+}
+
+// writeHeader write header and status code of reply
+func writeHeader(w http.ResponseWriter, reply *Reply, size int) {
+	for k, v := range reply.Header {
+		w.Header().Set(k, v)
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+
+	status := reply.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+}
+
+// sleep sleep for duration d, returns false if request is canceled
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// resetConn close the connection with RST
+func resetConn(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	if c, ok := conn.(*net.TCPConn); ok {
+		_ = c.SetLinger(0)
+	}
+
+	conn.Close()
+}
+
+// closeConn close the connection
+func closeConn(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	conn.Close()
+}
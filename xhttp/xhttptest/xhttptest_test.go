@@ -0,0 +1,158 @@
+/*
+ * Copyright 2012-2019 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * A toolkit for Golang development
+ * https://www.likexian.com/
+ */
+
+package xhttptest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestVersion(t *testing.T) {
+	assert.Contains(t, Version(), ".")
+	assert.Contains(t, Author(), "likexian")
+	assert.Contains(t, License(), "Apache License")
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	route := s.Handle("GET", "/get", Reply{Status: 201, Header: map[string]string{"X-Test": "test"}, Body: "likexian"})
+	s.Handle("*", "/any/*", Reply{Body: "any"})
+	s.Handle("POST", "/post")
+
+	rsp, err := http.Get(s.URL + "/get?a=1")
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, rsp.StatusCode, 201)
+	assert.Equal(t, rsp.Header.Get("X-Test"), "test")
+	assert.Equal(t, string(body), "likexian")
+	assert.Equal(t, s.Calls(route), 1)
+
+	for _, v := range []string{"GET", "DELETE"} {
+		req, _ := http.NewRequest(v, s.URL+"/any/path", nil)
+		rsp, err = http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		body, err = ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, string(body), "any")
+	}
+
+	rsp, err = http.Post(s.URL+"/post", "text/plain", strings.NewReader("likexian"))
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, rsp.StatusCode, 200)
+
+	rsp, err = http.Get(s.URL + "/404")
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, rsp.StatusCode, 404)
+
+	assert.Equal(t, len(s.Requests("", "")), 5)
+	assert.Equal(t, len(s.Requests("get", "")), 3)
+	assert.True(t, s.LastRequest("PUT", "/put") == nil)
+
+	s.AssertCalled(t, "GET", "/get", 1)
+	s.AssertCalled(t, "", "/any/path", 2)
+	s.AssertQuery(t, "GET", "/get", "a", "1")
+	s.AssertHeader(t, "POST", "/post", "Content-Type", "text/plain")
+	s.AssertBody(t, "POST", "/post", "likexian")
+
+	s.Reset()
+	assert.Equal(t, len(s.Requests("", "")), 0)
+	rsp, err = http.Get(s.URL + "/get")
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, rsp.StatusCode, 404)
+}
+
+func TestSequence(t *testing.T) {
+	s := NewTLSServer()
+	defer s.Close()
+
+	s.Handle("GET", "/retry", Reply{Status: 503}, Reply{Status: 502}, Reply{Status: 200, Body: "ok"})
+	for _, v := range []int{503, 502, 200, 200} {
+		rsp, err := s.Client().Get(s.URL + "/retry")
+		assert.Nil(t, err)
+		rsp.Body.Close()
+		assert.Equal(t, rsp.StatusCode, v)
+	}
+
+	s.AssertCalled(t, "GET", "/retry", 4)
+}
+
+func TestFault(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.Handle("GET", "/reset", Reply{Fault: FaultReset})
+	s.Handle("GET", "/partial", Reply{Body: "likexian", Fault: FaultPartialBody})
+	s.Handle("GET", "/slow", Reply{Body: "likexian", BodyDelay: 10 * time.Millisecond, Fault: FaultSlowBody})
+	s.Handle("GET", "/delay", Reply{Body: "likexian", Delay: 100 * time.Millisecond})
+
+	_, err := http.Get(s.URL + "/reset")
+	assert.NotNil(t, err)
+
+	rsp, err := http.Get(s.URL + "/partial")
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.NotNil(t, err)
+	assert.Equal(t, string(body), "like")
+
+	startAt := time.Now()
+	rsp, err = http.Get(s.URL + "/slow")
+	assert.Nil(t, err)
+	body, err = ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, string(body), "likexian")
+	assert.Ge(t, time.Since(startAt), 70*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", s.URL+"/delay", nil)
+	_, err = http.DefaultClient.Do(req.WithContext(ctx))
+	assert.NotNil(t, err)
+
+	s.Handle("GET", "/recover", Reply{Fault: FaultReset}, Reply{Status: 503}, Reply{Body: "ok"})
+	_, err = http.Get(s.URL + "/recover")
+	assert.NotNil(t, err)
+	rsp, err = http.Get(s.URL + "/recover")
+	assert.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, rsp.StatusCode, 503)
+	rsp, err = http.Get(s.URL + "/recover")
+	assert.Nil(t, err)
+	body, err = ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, string(body), "ok")
+	s.AssertCalled(t, "GET", "/recover", 3)
+}
@@ -275,7 +275,7 @@ func (l *Logger) writeLog() {
 				l.Unlock()
 				return
 			}
-			_, err := fmt.Fprintf(l.logFile.writer, s)
+			_, err := fmt.Fprint(l.logFile.writer, s)
 			if err == nil {
 				l.logFile.rotateNowSize += int64(len(s))
 			}
@@ -20,6 +20,7 @@
 package xlog
 
 import (
+	"io/ioutil"
 	"os"
 	"sync"
 	"testing"
@@ -67,8 +68,14 @@ func TestLogger(t *testing.T) {
 	log.Warn("This is Warn")
 	log.Error("This is Error")
 	log.Error("This is %s", "Args")
+	log.Error("This is %s", "100%")
 	log.Close()
 
+	text, err := ioutil.ReadFile("test.log")
+	assert.Nil(t, err)
+	assert.Contains(t, string(text), "This is Args")
+	assert.Contains(t, string(text), "This is 100%\n")
+
 	// will be ignore
 	log.Info("Log after closed")
 }
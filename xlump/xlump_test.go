@@ -20,7 +20,6 @@
 package xlump
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -28,7 +27,6 @@ import (
 
 	"github.com/likexian/gokit/assert"
 	"github.com/likexian/gokit/xfile"
-	"github.com/likexian/gokit/xhttp"
 )
 
 func TestVersion(t *testing.T) {
@@ -104,23 +102,23 @@ func TestHttpStatus(t *testing.T) {
 		_ = http.ListenAndServe("127.0.0.1:6666", nil)
 	}()
 
-	req := xhttp.New()
+	// xhttp imports xlump for batch requests, net/http is used here to avoid import cycle
 	for {
-		_, err := req.Do(context.Background(), "GET", fmt.Sprintf("http://127.0.0.1:6666/"))
+		rsp, err := http.Get("http://127.0.0.1:6666/")
 		if err == nil {
+			rsp.Body.Close()
 			break
 		}
 	}
 
 	getStatus := func(t Task) Task {
-		ctx := context.Background()
-		rsp, err := xhttp.New().Get(ctx, fmt.Sprintf("http://127.0.0.1:6666/status/%d", t.(int)))
+		rsp, err := http.Get(fmt.Sprintf("http://127.0.0.1:6666/status/%d", t.(int)))
 		if err != nil {
 			return 0
 		}
 
-		defer rsp.Close()
-		return rsp.Response.StatusCode
+		defer rsp.Body.Close()
+		return rsp.StatusCode
 	}
 
 	sumStatus := func(r Task, t Task) Task {